@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	snapHelper "github.com/vpngen/keydesk-snap/core/helper"
+	"github.com/vpngen/keydesk/keydesk/storage"
+)
+
+const (
+	// SnapEtcDir is a dir of the snapshot tools configs.
+	SnapEtcDir = "/etc/vg-keydesk-snap"
+	// ExcludeFileName is a file of the brigades never discovered,
+	// one ID per line.
+	ExcludeFileName = "exclude_brigades"
+)
+
+var ErrNotOwner = fmt.Errorf("dir owner is not the brigade user")
+
+// readExcludeFile reads the excluded brigades, no file means none.
+func readExcludeFile(etcDir string) ([]string, error) {
+	data, err := snapHelper.ReadFileSafeSize(filepath.Join(etcDir, ExcludeFileName), snapCore.MaxKeysFileSize)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("read exclude list: %w", err)
+	}
+
+	ids := []string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		ids = append(ids, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan exclude list: %w", err)
+	}
+
+	return ids, nil
+}
+
+// discoverBrigades returns the brigades of the home dir: the dirs
+// named by the brigade ID with the brigade file. Unless in the debug
+// mode the dir must be owned by the system user of the same name.
+// The excluded brigades are skipped.
+func discoverBrigades(home string, exclude []string, debug bool) ([]string, error) {
+	entries, err := os.ReadDir(home)
+	if err != nil {
+		return nil, fmt.Errorf("read home dir: %w", err)
+	}
+
+	excluded := map[string]bool{}
+	for _, id := range exclude {
+		excluded[id] = true
+	}
+
+	brigades := []string{}
+
+	for _, entry := range entries {
+		id := entry.Name()
+
+		if !entry.IsDir() || !validBrigadeID(id) {
+			continue
+		}
+
+		if excluded[id] {
+			fmt.Fprintf(os.Stderr, "Skip %s: excluded\n", id)
+
+			continue
+		}
+
+		// the unreadable dir is not skipped, the snapshot reports it
+		if _, err := os.Stat(filepath.Join(home, id, storage.BrigadeFilename)); errors.Is(err, fs.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Skip %s: no %s\n", id, storage.BrigadeFilename)
+
+			continue
+		}
+
+		if !debug {
+			if err := checkOwner(entry, id); err != nil {
+				fmt.Fprintf(os.Stderr, "Skip %s: %s\n", id, err)
+
+				continue
+			}
+		}
+
+		brigades = append(brigades, id)
+	}
+
+	return brigades, nil
+}
+
+// checkOwner checks the dir is owned by the system user of the brigade.
+func checkOwner(entry fs.DirEntry, id string) error {
+	u, err := user.Lookup(id)
+	if err != nil {
+		return fmt.Errorf("lookup user: %w", err)
+	}
+
+	fi, err := entry.Info()
+	if err != nil {
+		return fmt.Errorf("stat: %w", err)
+	}
+
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || strconv.FormatUint(uint64(st.Uid), 10) != u.Uid {
+		return ErrNotOwner
+	}
+
+	return nil
+}
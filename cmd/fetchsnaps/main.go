@@ -0,0 +1,492 @@
+// fetchsnaps makes the snapshots of the brigades of the host
+// and writes them as the one JSON bundle.
+package main
+
+import (
+	"bufio"
+	"encoding/base32"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	snapCrypto "github.com/vpngen/keydesk-snap/core/crypto"
+	snapDelta "github.com/vpngen/keydesk-snap/core/delta"
+	snapPSK "github.com/vpngen/keydesk-snap/core/psk"
+	snapSnap "github.com/vpngen/keydesk-snap/core/snap"
+	snapValidate "github.com/vpngen/keydesk-snap/core/validate"
+	"github.com/vpngen/keydesk/keydesk/storage"
+)
+
+const (
+	// SnapAppPath is a dir of the installed snapshot tools.
+	SnapAppPath = "/opt/vgkeydesk-snap"
+	// SnapshotBinName is a name of the brigade snapshot tool.
+	SnapshotBinName = "snapshot"
+	// ServiceUser is a user the realm runs the fetch as, the brigades
+	// are run with the setuid and setgid capabilities of the installed
+	// fetch, root can run it too. Any other user means the debug mode.
+	ServiceUser = "_onotole_"
+
+	// PSKReadTimeout is a maximum time to wait for the PSK on the stdin.
+	PSKReadTimeout = 10 * time.Second
+
+	// BrigadeIDSize is a size of the decoded brigade ID.
+	BrigadeIDSize = 16
+
+	// Error response descriptions.
+	DescBadRequest    = "Bad request"
+	DescInternalError = "Internal error"
+
+	DefaultDebugDbDir   = "../../../vpngen-keydesk/cmd/keydesk"
+	DefaultDebugConfDir = "../../core/crypto/testdata"
+)
+
+var (
+	ErrEmptyTag          = fmt.Errorf("empty tag")
+	ErrEmptySnapAt       = fmt.Errorf("empty global snapshot time")
+	ErrEmptyRealmFP      = fmt.Errorf("empty realm fingerprint")
+	ErrEmptyList         = fmt.Errorf("empty brigades list")
+	ErrInvalidBrigadeID  = fmt.Errorf("invalid brigade id")
+	ErrDuplicateBrigade  = fmt.Errorf("duplicate brigade id")
+	ErrUnknownCompress   = fmt.Errorf("unknown compression")
+	ErrDebugOnly         = fmt.Errorf("the option is only for debug")
+	ErrNotDir            = fmt.Errorf("not a directory")
+	ErrPSKTimeout        = fmt.Errorf("exceeded psk reading time")
+	ErrSnapshotNotFound  = fmt.Errorf("no snap tool found")
+	ErrIncrementalRebase = fmt.Errorf("both incremental and rebase")
+	ErrBaseNoIncremental = fmt.Errorf("base without incremental")
+	ErrListAll           = fmt.Errorf("both list and all")
+	ErrExcludeWithoutAll = fmt.Errorf("exclude without all")
+)
+
+type CommandOpts struct {
+	Brigades []string
+	RealmFP  string
+	Workers  int
+	Debug    bool
+	// Stream writes the NDJSON records instead of the one bundle.
+	Stream bool
+	// Chunked frames the output as the HTTP chunks.
+	Chunked bool
+	// All means the brigades are discovered in the home dir
+	// except the Exclude ones.
+	All     bool
+	Exclude []string
+	// Incremental means the snapshots keep the references,
+	// the brigades need the reference keys.
+	Incremental bool
+
+	// SnapArgs are the snapshot tool flags common for all the brigades.
+	SnapArgs []string
+	// DbDir and ConfDir are the dirs for the debug mode,
+	// DbDir is the home dir of the brigades with All.
+	DbDir   string
+	ConfDir string
+}
+
+// stdout is the output of the records and the error response.
+var stdout = &recordWriter{w: bufio.NewWriter(os.Stdout)}
+
+func main() {
+	debug := true
+	if u, err := user.Current(); err == nil && (u.Username == ServiceUser || u.Uid == "0") {
+		debug = false
+	}
+
+	opts, err := parseArgs(os.Args[1:], debug)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Usage: echo \"$PSK\" | %s -tag <tag> -stime <global_snapshot_at> -rfp <realm key FP> -mnt <maintenance_till> [-archive] [-compress <none|gzip|zstd>] [-pad <pow2|size,...>] [-incremental [-base <tag>]|-rebase] [-validate <off|warn|strict>] [-meta] [-lock-timeout <duration>] [-workers <n>] [-ndjson] [-chunked] <-list <brigade_id, ...>|-all [-exclude <brigade_id, ...>]>\n", os.Args[0])
+		fatal(http.StatusBadRequest, DescBadRequest, err.Error())
+	}
+
+	stdout.chunked = opts.Chunked
+
+	psk, err := readPSK(os.Stdin, PSKReadTimeout)
+	if err != nil {
+		fatal(http.StatusBadRequest, DescBadRequest, fmt.Sprintf("Invalid PSK: %s", err))
+	}
+
+	bin, err := snapshotBin(opts.Debug)
+	if err != nil {
+		fatal(http.StatusInternalServerError, DescInternalError, err.Error())
+	}
+
+	if opts.All {
+		if opts.Brigades, err = allBrigades(opts); err != nil {
+			fatal(http.StatusInternalServerError, DescInternalError, fmt.Sprintf("Discover brigades: %s", err))
+		}
+	}
+
+	var out output = &bundleOutput{rw: stdout, results: make([]result, len(opts.Brigades))}
+	if opts.Stream {
+		out = &streamOutput{rw: stdout}
+	}
+
+	if opts.All {
+		if err := out.discovered(opts.Brigades); err != nil {
+			fatal(http.StatusInternalServerError, DescInternalError, fmt.Sprintf("Output: %s", err))
+		}
+	}
+
+	r := &runner{bin: bin, opts: opts, psk: psk}
+
+	// the brigades are run as the brigade users by the fetch itself
+	if !opts.Debug {
+		if r.keys, err = keyMaterial(SnapEtcDir, opts.RealmFP); err != nil {
+			if errors.Is(err, snapCrypto.ErrKeyNotFound) {
+				fatal(http.StatusBadRequest, DescBadRequest, err.Error())
+			}
+
+			fatal(http.StatusInternalServerError, DescInternalError, err.Error())
+		}
+
+		if opts.Incremental {
+			if r.refKey, err = snapDelta.ReadReferenceKey(filepath.Join(SnapEtcDir, snapDelta.ReferenceKeyFileName)); err != nil {
+				fatal(http.StatusInternalServerError, DescInternalError, fmt.Sprintf("Reference key: %s", err))
+			}
+		}
+	}
+
+	if err := fetchSnaps(r, opts.Brigades, opts.Workers, out); err != nil {
+		fatal(http.StatusInternalServerError, DescInternalError, fmt.Sprintf("Output: %s", err))
+	}
+}
+
+// allBrigades returns the brigades discovered in the home dir.
+func allBrigades(opts *CommandOpts) ([]string, error) {
+	home, etcDir := storage.DefaultHomeDir, SnapEtcDir
+	if opts.Debug {
+		home, etcDir = opts.DbDir, opts.ConfDir
+	}
+
+	exclude, err := readExcludeFile(etcDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return discoverBrigades(home, append(exclude, opts.Exclude...), opts.Debug)
+}
+
+// fatal writes the error response and exits.
+func fatal(code int, desc, msg string) {
+	stdout.write(&snapCore.ErrorResponse{
+		Code:    code,
+		Desc:    desc,
+		Status:  "error",
+		Message: msg,
+	})
+	stdout.close()
+
+	os.Exit(1)
+}
+
+// readPSK reads the PSK line from the stdin. The PSK is sent
+// over the SSH session which may keep the stdin open, so the end
+// of line is enough and the read is limited by the timeout.
+func readPSK(r io.Reader, timeout time.Duration) ([]byte, error) {
+	type result struct {
+		psk []byte
+		err error
+	}
+
+	ch := make(chan result, 1)
+
+	go func() {
+		line, err := bufio.NewReader(io.LimitReader(r, 128)).ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			ch <- result{err: fmt.Errorf("read: %w", err)}
+
+			return
+		}
+
+		psk, err := snapPSK.Decode(line)
+		ch <- result{psk: psk, err: err}
+	}()
+
+	select {
+	case res := <-ch:
+		return res.psk, res.err
+	case <-time.After(timeout):
+		return nil, ErrPSKTimeout
+	}
+}
+
+// snapshotBin returns the snapshot tool path: the installed one
+// or, in the debug mode, the one next to the executable or in the PATH.
+func snapshotBin(debug bool) (string, error) {
+	candidates := []string{filepath.Join(SnapAppPath, SnapshotBinName)}
+
+	if !debug {
+		if !isExecutable(candidates[0]) {
+			return "", fmt.Errorf("%w: %s", ErrSnapshotNotFound, candidates[0])
+		}
+
+		return candidates[0], nil
+	}
+
+	if exe, err := os.Executable(); err == nil {
+		candidates = append(candidates, filepath.Join(filepath.Dir(exe), SnapshotBinName))
+	}
+
+	for _, name := range candidates {
+		if isExecutable(name) {
+			return name, nil
+		}
+	}
+
+	name, err := exec.LookPath(SnapshotBinName)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrSnapshotNotFound, err)
+	}
+
+	return name, nil
+}
+
+func isExecutable(name string) bool {
+	fi, err := os.Stat(name)
+
+	return err == nil && fi.Mode().IsRegular() && fi.Mode().Perm()&0o111 != 0
+}
+
+func parseArgs(args []string, debug bool) (*CommandOpts, error) {
+	fs := flag.NewFlagSet("fetchsnaps", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	tag := fs.String("tag", "", "Tag for snapshot")
+	snapAt := fs.String("stime", "", "Global snapshot time")
+	realmFP := fs.String("rfp", "", "Realm fingerprint")
+	list := fs.String("list", "", "Comma separated brigade IDs")
+	all := fs.Bool("all", false, "Snapshot all the brigades of the host")
+	exclude := fs.String("exclude", "", "Comma separated brigade IDs skipped with -all, in addition to the "+ExcludeFileName+" config")
+	maintenance := fs.String("mnt", "", "Maintenance time (unix timestamp)")
+	archive := fs.Bool("archive", false, "Archive the allowlisted brigade dir files")
+	meta := fs.Bool("meta", false, "Add the public metadata to the envelopes")
+	incremental := fs.Bool("incremental", false, "Make the incremental snapshots")
+	rebase := fs.Bool("rebase", false, "Make the full snapshots and reset the references")
+	baseTag := fs.String("base", "", "Tag of the last snapshot delivered to the realm, confirms the incremental references")
+	validate := fs.String("validate", "", "Brigade validation: "+snapValidate.StrictnessOff+", "+snapValidate.StrictnessWarn+" or "+snapValidate.StrictnessStrict)
+	compress := fs.String("compress", "", "Payload compression: "+snapCore.CompressionNone+", "+snapCore.CompressionGzip+" or "+snapCore.CompressionZstd)
+	padding := fs.String("pad", "", "Payload size padding")
+	lockTimeout := fs.Duration("lock-timeout", 0, "Maximum time to wait for the brigade file lock. Default: the snapshot tool default")
+	workers := fs.Int("workers", runtime.NumCPU(), "Number of the brigades snapshotted in parallel")
+	stream := fs.Bool("ndjson", false, "Write the NDJSON record of each brigade as soon as it is ready and the summary record at the end")
+	chunked := fs.Bool("chunked", false, "Frame the output as the HTTP chunks")
+	dbDir := fs.String("d", "", "Dir for db files, the home dir with -all (for debug)")
+	confDir := fs.String("c", "", "Dir for config files (for debug)")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unknown option: %s", fs.Arg(0))
+	}
+
+	switch {
+	case *tag == "":
+		return nil, ErrEmptyTag
+	case *snapAt == "":
+		return nil, ErrEmptySnapAt
+	case *realmFP == "":
+		return nil, ErrEmptyRealmFP
+	case *list == "" && !*all:
+		return nil, ErrEmptyList
+	case *list != "" && *all:
+		return nil, ErrListAll
+	case *exclude != "" && !*all:
+		return nil, ErrExcludeWithoutAll
+	case *incremental && *rebase:
+		return nil, ErrIncrementalRebase
+	case !debug && (*dbDir != "" || *confDir != ""):
+		return nil, ErrDebugOnly
+	}
+
+	gst, err := strconv.ParseInt(*snapAt, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parse snap time: %w", err)
+	}
+
+	parsedTag, err := snapCore.ParseTag(*tag)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := parsedTag.CheckTime(time.Unix(gst, 0)); err != nil {
+		return nil, err
+	}
+
+	snapArgs := []string{"-tag", *tag, "-stime", *snapAt, "-rfp", *realmFP}
+
+	if *maintenance != "" {
+		if _, err := strconv.ParseUint(*maintenance, 10, 63); err != nil {
+			return nil, fmt.Errorf("parse maintenance time: %w", err)
+		}
+
+		snapArgs = append(snapArgs, "-mnt", *maintenance)
+	}
+
+	if *archive {
+		snapArgs = append(snapArgs, "-archive")
+	}
+
+	if *compress != "" {
+		switch *compress {
+		case snapCore.CompressionNone, snapCore.CompressionGzip, snapCore.CompressionZstd:
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnknownCompress, *compress)
+		}
+
+		snapArgs = append(snapArgs, "-compress", *compress)
+	}
+
+	if *padding != "" {
+		if _, err := snapSnap.ParsePadding(*padding); err != nil {
+			return nil, fmt.Errorf("padding: %w", err)
+		}
+
+		if *meta {
+			return nil, snapSnap.ErrMetaPadding
+		}
+
+		snapArgs = append(snapArgs, "-pad", *padding)
+	}
+
+	switch {
+	case *incremental:
+		snapArgs = append(snapArgs, "-incremental")
+	case *rebase:
+		snapArgs = append(snapArgs, "-rebase")
+	}
+
+	if *baseTag != "" {
+		if !*incremental {
+			return nil, ErrBaseNoIncremental
+		}
+
+		if _, err := snapCore.ParseTag(*baseTag); err != nil {
+			return nil, fmt.Errorf("base: %w", err)
+		}
+
+		snapArgs = append(snapArgs, "-base", *baseTag)
+	}
+
+	if *validate != "" {
+		if err := snapValidate.CheckStrictness(*validate); err != nil {
+			return nil, err
+		}
+
+		snapArgs = append(snapArgs, "-validate", *validate)
+	}
+
+	if *meta {
+		snapArgs = append(snapArgs, "-meta")
+	}
+
+	if *lockTimeout > 0 {
+		snapArgs = append(snapArgs, "-lock-timeout", lockTimeout.String())
+	}
+
+	opts := &CommandOpts{
+		RealmFP:  *realmFP,
+		Workers:  max(*workers, 1),
+		Debug:    debug,
+		Stream:   *stream,
+		Chunked:  *chunked,
+		All:      *all,
+		SnapArgs: snapArgs,
+
+		Incremental: *incremental || *rebase,
+	}
+
+	if *list != "" {
+		if opts.Brigades, err = parseBrigades(*list); err != nil {
+			return nil, err
+		}
+	}
+
+	if *exclude != "" {
+		if opts.Exclude, err = parseBrigades(*exclude); err != nil {
+			return nil, fmt.Errorf("exclude: %w", err)
+		}
+	}
+
+	if debug {
+		if opts.DbDir, err = debugDir(*dbDir, DefaultDebugDbDir); err != nil {
+			return nil, fmt.Errorf("db dir: %w", err)
+		}
+
+		if opts.ConfDir, err = debugDir(*confDir, DefaultDebugConfDir); err != nil {
+			return nil, fmt.Errorf("conf dir: %w", err)
+		}
+	}
+
+	return opts, nil
+}
+
+// parseBrigades parses and checks the comma separated brigade IDs.
+func parseBrigades(list string) ([]string, error) {
+	var brigades []string
+
+	seen := map[string]bool{}
+
+	// the empty entries, as of the trailing comma, are skipped
+	for _, id := range strings.Split(list, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+
+		if !validBrigadeID(id) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidBrigadeID, id)
+		}
+
+		if seen[id] {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateBrigade, id)
+		}
+
+		seen[id] = true
+		brigades = append(brigades, id)
+	}
+
+	if len(brigades) == 0 {
+		return nil, ErrEmptyList
+	}
+
+	return brigades, nil
+}
+
+// validBrigadeID checks the brigade ID is the base32 encoded ID.
+func validBrigadeID(id string) bool {
+	buf, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(id)
+
+	return err == nil && len(buf) == BrigadeIDSize
+}
+
+// debugDir returns the absolute dir or the default one.
+func debugDir(dir, def string) (string, error) {
+	if dir == "" {
+		dir = def
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	if fi, err := os.Stat(abs); err != nil || !fi.IsDir() {
+		return "", fmt.Errorf("%w: %s", ErrNotDir, abs)
+	}
+
+	return abs, nil
+}
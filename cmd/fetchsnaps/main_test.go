@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	snapSnap "github.com/vpngen/keydesk-snap/core/snap"
+	snapValidate "github.com/vpngen/keydesk-snap/core/validate"
+)
+
+const (
+	testTag      = "2023-01-01T00:00:00Z-regular-quarter-snapshot"
+	testSnapAt   = "1672531200"
+	testRealmFP  = "SHA256:g3+OoyULfxUvOr/JTcpY0ZgIajOqPq+BU8Eff6wHMwk"
+	testBrigade1 = "AAAAAAAAAAAAAAAAAAAAAAAAAA"
+	testBrigade2 = "AAAAAAAAAAAAAAAAAAAAAAAAAE"
+)
+
+func Test_parseArgs(t *testing.T) {
+	base := []string{"-tag", testTag, "-stime", testSnapAt, "-rfp", testRealmFP}
+	snapBase := []string{"-tag", testTag, "-stime", testSnapAt, "-rfp", testRealmFP}
+
+	tests := []struct {
+		name        string
+		args        []string
+		brigades    []string
+		snapArgs    []string
+		all         bool
+		incremental bool
+		err         error
+	}{
+		{
+			name:     "list",
+			args:     append(slices.Clone(base), "-list", testBrigade1+", "+testBrigade2),
+			brigades: []string{testBrigade1, testBrigade2},
+			snapArgs: snapBase,
+		},
+		{
+			name:     "all",
+			args:     append(slices.Clone(base), "-all", "-exclude", testBrigade2),
+			snapArgs: snapBase,
+			all:      true,
+		},
+		{
+			name: "snapshot flags",
+			args: append(slices.Clone(base), "-list", testBrigade1, "-mnt", "1700000000", "-archive", "-compress", snapCore.CompressionZstd,
+				"-pad", snapSnap.PaddingPowerOfTwo, "-incremental", "-base", testTag, "-validate", snapValidate.StrictnessStrict, "-lock-timeout", "5s"),
+			brigades: []string{testBrigade1},
+			snapArgs: append(slices.Clone(snapBase), "-mnt", "1700000000", "-archive", "-compress", snapCore.CompressionZstd,
+				"-pad", snapSnap.PaddingPowerOfTwo, "-incremental", "-base", testTag, "-validate", snapValidate.StrictnessStrict, "-lock-timeout", "5s"),
+			incremental: true,
+		},
+		{
+			name:        "meta",
+			args:        append(slices.Clone(base), "-list", testBrigade1, "-meta", "-rebase"),
+			brigades:    []string{testBrigade1},
+			snapArgs:    append(slices.Clone(snapBase), "-rebase", "-meta"),
+			incremental: true,
+		},
+		{name: "no tag", args: []string{"-stime", testSnapAt, "-rfp", testRealmFP, "-list", testBrigade1}, err: ErrEmptyTag},
+		{name: "no time", args: []string{"-tag", testTag, "-rfp", testRealmFP, "-list", testBrigade1}, err: ErrEmptySnapAt},
+		{name: "no realm", args: []string{"-tag", testTag, "-stime", testSnapAt, "-list", testBrigade1}, err: ErrEmptyRealmFP},
+		{name: "no list", args: base, err: ErrEmptyList},
+		{name: "list and all", args: append(slices.Clone(base), "-list", testBrigade1, "-all"), err: ErrListAll},
+		{name: "exclude without all", args: append(slices.Clone(base), "-list", testBrigade1, "-exclude", testBrigade2), err: ErrExcludeWithoutAll},
+		{name: "incremental and rebase", args: append(slices.Clone(base), "-list", testBrigade1, "-incremental", "-rebase"), err: ErrIncrementalRebase},
+		{name: "base without incremental", args: append(slices.Clone(base), "-list", testBrigade1, "-base", testTag), err: ErrBaseNoIncremental},
+		{name: "debug dir", args: append(slices.Clone(base), "-list", testBrigade1, "-d", "."), err: ErrDebugOnly},
+		{name: "invalid tag", args: []string{"-tag", "snapshot", "-stime", testSnapAt, "-rfp", testRealmFP, "-list", testBrigade1}, err: snapCore.ErrInvalidTag},
+		{name: "tag time", args: []string{"-tag", testTag, "-stime", "1672531201", "-rfp", testRealmFP, "-list", testBrigade1}, err: snapCore.ErrTagTime},
+		{name: "compression", args: append(slices.Clone(base), "-list", testBrigade1, "-compress", "lzma"), err: ErrUnknownCompress},
+		{name: "padding", args: append(slices.Clone(base), "-list", testBrigade1, "-pad", "1x"), err: snapSnap.ErrInvalidPadding},
+		{name: "meta with padding", args: append(slices.Clone(base), "-list", testBrigade1, "-pad", "64k", "-meta"), err: snapSnap.ErrMetaPadding},
+		{name: "validation", args: append(slices.Clone(base), "-list", testBrigade1, "-validate", "loose"), err: snapValidate.ErrUnknownStrictness},
+		{name: "invalid brigade", args: append(slices.Clone(base), "-list", "brigade1"), err: ErrInvalidBrigadeID},
+		{name: "invalid exclude", args: append(slices.Clone(base), "-all", "-exclude", "brigade1"), err: ErrInvalidBrigadeID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := parseArgs(tt.args, false)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("parseArgs() error = %v, want %v", err, tt.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseArgs() error = %v", err)
+			}
+
+			if !slices.Equal(opts.Brigades, tt.brigades) || opts.All != tt.all || opts.Debug {
+				t.Errorf("parseArgs() brigades = %v, all = %t, debug = %t, want %v, %t", opts.Brigades, opts.All, opts.Debug, tt.brigades, tt.all)
+			}
+
+			if !slices.Equal(opts.SnapArgs, tt.snapArgs) {
+				t.Errorf("parseArgs() snapshot args = %q, want %q", opts.SnapArgs, tt.snapArgs)
+			}
+
+			if opts.Incremental != tt.incremental {
+				t.Errorf("parseArgs() incremental = %t, want %t", opts.Incremental, tt.incremental)
+			}
+		})
+	}
+}
+
+func Test_parseBrigades(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+		err  error
+	}{
+		{list: testBrigade1, want: []string{testBrigade1}},
+		{list: " " + testBrigade1 + " ," + testBrigade2, want: []string{testBrigade1, testBrigade2}},
+		{list: testBrigade1 + ",", want: []string{testBrigade1}},
+		{list: testBrigade1 + ",, " + testBrigade2, want: []string{testBrigade1, testBrigade2}},
+		{list: "", err: ErrEmptyList},
+		{list: " , ,", err: ErrEmptyList},
+		{list: "aaaaaaaaaaaaaaaaaaaaaaaaaa", err: ErrInvalidBrigadeID},
+		{list: testBrigade1 + "A", err: ErrInvalidBrigadeID},
+		{list: "../" + testBrigade1, err: ErrInvalidBrigadeID},
+		{list: testBrigade1 + "," + testBrigade1, err: ErrDuplicateBrigade},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.list, func(t *testing.T) {
+			got, err := parseBrigades(tt.list)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseBrigades() error = %v, want %v", err, tt.err)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("parseBrigades() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+)
+
+// output gets the brigade results as they are ready.
+type output interface {
+	discovered(ids []string) error
+	add(i int, res result) error
+	finish() error
+}
+
+// recordWriter writes the JSON records line by line,
+// optionally framed as the HTTP chunks.
+type recordWriter struct {
+	w       *bufio.Writer
+	chunked bool
+}
+
+func (rw *recordWriter) write(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+
+	data = append(data, '\n')
+
+	if rw.chunked {
+		fmt.Fprintf(rw.w, "%x\r\n", len(data))
+	}
+
+	rw.w.Write(data)
+
+	if rw.chunked {
+		rw.w.WriteString("\r\n")
+	}
+
+	if err := rw.w.Flush(); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	return nil
+}
+
+// close writes the last chunk.
+func (rw *recordWriter) close() error {
+	if !rw.chunked {
+		return nil
+	}
+
+	rw.w.WriteString("0\r\n\r\n")
+
+	if err := rw.w.Flush(); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	return nil
+}
+
+// bundleOutput writes the one bundle of all the brigades
+// in the order of the brigades list.
+type bundleOutput struct {
+	rw       *recordWriter
+	results  []result
+	brigades []string
+}
+
+func (o *bundleOutput) discovered(ids []string) error {
+	o.brigades = ids
+
+	return nil
+}
+
+func (o *bundleOutput) add(i int, res result) error {
+	o.results[i] = res
+
+	return nil
+}
+
+func (o *bundleOutput) finish() error {
+	bundle := &snapCore.SnapBundle{Snaps: []*snapCore.EncryptedBrigade{}, Discovered: o.brigades}
+
+	for _, res := range o.results {
+		bundle.TotalCount++
+
+		if res.err != nil {
+			bundle.ErrorsCount++
+			bundle.Errors = append(bundle.Errors, *res.err)
+
+			continue
+		}
+
+		bundle.Snaps = append(bundle.Snaps, res.snap)
+	}
+
+	if err := o.rw.write(bundle); err != nil {
+		return err
+	}
+
+	return o.rw.close()
+}
+
+// streamOutput writes the record of each brigade as soon as it is ready
+// and the summary record at the end, so the records are in the order
+// of completion. The discovered brigades record is the first one.
+type streamOutput struct {
+	rw      *recordWriter
+	summary snapCore.SnapSummary
+}
+
+func (o *streamOutput) discovered(ids []string) error {
+	return o.rw.write(&snapCore.SnapRecord{Type: snapCore.RecordTypeDiscovered, Discovered: ids})
+}
+
+func (o *streamOutput) add(_ int, res result) error {
+	o.summary.TotalCount++
+
+	record := &snapCore.SnapRecord{Type: snapCore.RecordTypeSnap, Snap: res.snap}
+
+	if res.err != nil {
+		o.summary.ErrorsCount++
+
+		record = &snapCore.SnapRecord{Type: snapCore.RecordTypeError, Error: res.err}
+	}
+
+	return o.rw.write(record)
+}
+
+func (o *streamOutput) finish() error {
+	if err := o.rw.write(&snapCore.SnapRecord{Type: snapCore.RecordTypeSummary, Summary: &o.summary}); err != nil {
+		return err
+	}
+
+	return o.rw.close()
+}
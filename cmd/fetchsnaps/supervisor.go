@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	snapCrypto "github.com/vpngen/keydesk-snap/core/crypto"
+)
+
+const (
+	// SafePath is a PATH of the brigade snapshot processes.
+	SafePath = "/usr/sbin:/usr/bin:/sbin:/bin"
+
+	// Inherited file descriptors of the brigade snapshot process.
+	PSKFD          = 3
+	KeysFD         = 4
+	ReferenceKeyFD = 5
+)
+
+// keyMaterial returns the encoded key material of the snapshots,
+// it is read once and passed to all the brigade processes.
+func keyMaterial(etcDir, realmFP string) ([]byte, error) {
+	realmKey, err := snapCrypto.FindPubKeyInFile(filepath.Join(etcDir, snapCrypto.DefaultRealmsKeysFileName), realmFP)
+	if err != nil {
+		return nil, fmt.Errorf("find realm key: %w", err)
+	}
+
+	authKeys, err := snapCrypto.ReadAuthoritiesPubKeyFile(etcDir)
+	if err != nil {
+		return nil, fmt.Errorf("read authorities keys: %w", err)
+	}
+
+	km, err := snapCrypto.NewKeyMaterial(realmKey, authKeys)
+	if err != nil {
+		return nil, fmt.Errorf("key material: %w", err)
+	}
+
+	return json.Marshal(km)
+}
+
+// brigadeCommand returns the snapshot tool command run as the brigade
+// user and group without the supplementary groups. The PSK and the key
+// material and the brigade reference key are passed over the pipes.
+func (r *runner) brigadeCommand(id string, args []string) (*exec.Cmd, *fdPipes, error) {
+	u, err := user.Lookup(id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("lookup user: %w", err)
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse uid: %w", err)
+	}
+
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse gid: %w", err)
+	}
+
+	data := [][]byte{[]byte(base64.StdEncoding.EncodeToString(r.psk)), r.keys}
+	args = append(args, "-psk-fd", strconv.Itoa(PSKFD), "-keys-fd", strconv.Itoa(KeysFD))
+
+	if r.refKey != nil {
+		refKey, err := snapCrypto.DeriveReferenceKey(r.refKey, id)
+		if err != nil {
+			return nil, nil, fmt.Errorf("derive reference key: %w", err)
+		}
+
+		data = append(data, refKey)
+		args = append(args, "-reference-key-fd", strconv.Itoa(ReferenceKeyFD))
+	}
+
+	pipes, err := newFDPipes(data...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("pipes: %w", err)
+	}
+
+	cmd := exec.Command(r.bin, args...)
+	cmd.Dir = u.HomeDir
+	cmd.Env = []string{"HOME=" + u.HomeDir, "USER=" + u.Username, "LOGNAME=" + u.Username, "PATH=" + SafePath}
+	cmd.ExtraFiles = pipes.r
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: []uint32{}},
+	}
+
+	return cmd, pipes, nil
+}
+
+// fdPipes passes the data to the child process over the pipes
+// inherited as the extra files.
+type fdPipes struct {
+	r, w []*os.File
+	data [][]byte
+}
+
+func newFDPipes(data ...[]byte) (*fdPipes, error) {
+	p := &fdPipes{data: data}
+
+	for range data {
+		r, w, err := os.Pipe()
+		if err != nil {
+			p.close()
+
+			return nil, err
+		}
+
+		p.r = append(p.r, r)
+		p.w = append(p.w, w)
+	}
+
+	return p, nil
+}
+
+// feed closes the child ends when the child is started and writes
+// the data in the background. The write fails if the child exits
+// without reading, so the failure is left to the child.
+func (p *fdPipes) feed() {
+	for _, r := range p.r {
+		r.Close()
+	}
+
+	for i, w := range p.w {
+		go func() {
+			defer w.Close()
+
+			w.Write(p.data[i])
+		}()
+	}
+}
+
+// close closes all the ends if the child is not started.
+func (p *fdPipes) close() {
+	for _, f := range append(p.r, p.w...) {
+		f.Close()
+	}
+}
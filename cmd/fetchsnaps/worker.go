@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	snapPSK "github.com/vpngen/keydesk-snap/core/psk"
+	snapSnap "github.com/vpngen/keydesk-snap/core/snap"
+)
+
+// MaxErrorMessageSize is a maximum size of the kept snapshot tool stderr.
+const MaxErrorMessageSize = 4096
+
+// logPrefixRe is a standard log prefix of the snapshot tool messages.
+var logPrefixRe = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} `)
+
+// brigadeRunner snapshots the brigade.
+type brigadeRunner interface {
+	run(id string) result
+}
+
+// runner runs the snapshot tool for the brigade.
+type runner struct {
+	bin  string
+	opts *CommandOpts
+	psk  []byte
+	// keys is the encoded key material for the brigade run,
+	// it is not used in the debug mode.
+	keys []byte
+	// refKey is the host reference key of the incremental run,
+	// the brigades get the derived keys. It is not used in the debug mode.
+	refKey []byte
+}
+
+// result is a snapshot or a failure of the brigade.
+type result struct {
+	snap *snapCore.EncryptedBrigade
+	err  *snapCore.SnapError
+}
+
+// indexedResult is a result of the brigade of the list index.
+type indexedResult struct {
+	i   int
+	res result
+}
+
+// fetchSnaps snapshots the brigades with the bounded worker pool
+// and passes the results to the output as they are ready.
+func fetchSnaps(r brigadeRunner, brigades []string, workers int, out output) error {
+	jobs := make(chan int)
+	results := make(chan indexedResult)
+
+	wg := &sync.WaitGroup{}
+
+	for range min(workers, len(brigades)) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				results <- indexedResult{i: i, res: r.run(brigades[i])}
+			}
+		}()
+	}
+
+	go func() {
+		for i := range brigades {
+			jobs <- i
+		}
+
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	// the output failure is fatal, the workers are left behind
+	for res := range results {
+		if err := out.add(res.i, res.res); err != nil {
+			return err
+		}
+	}
+
+	return out.finish()
+}
+
+// run snapshots the brigade as the brigade user,
+// in the debug mode as the current user.
+func (r *runner) run(id string) result {
+	args := append([]string{}, r.opts.SnapArgs...)
+
+	var (
+		cmd   *exec.Cmd
+		pipes *fdPipes
+		err   error
+	)
+
+	switch {
+	case r.opts.Debug:
+		dbDir := r.opts.DbDir
+		if r.opts.All {
+			dbDir = filepath.Join(dbDir, id)
+		}
+
+		args = append(args, "-id", id, "-d", dbDir, "-c", r.opts.ConfDir)
+		cmd = exec.Command(r.bin, args...)
+	default:
+		if cmd, pipes, err = r.brigadeCommand(id, args); err != nil {
+			return failed(id, snapCore.SnapErrorOther, fmt.Sprintf("Can't create snapshot %s: %s", id, err))
+		}
+	}
+
+	stdout := &bytes.Buffer{}
+	stderr := &tailBuffer{max: MaxErrorMessageSize}
+
+	if pipes == nil {
+		cmd.Stdin = strings.NewReader(base64.StdEncoding.EncodeToString(r.psk))
+	}
+
+	cmd.Stdout = stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+
+	if err := cmd.Start(); err != nil {
+		if pipes != nil {
+			pipes.close()
+		}
+
+		return failed(id, errorCode(err), fmt.Sprintf("Can't create snapshot %s: %s", id, err))
+	}
+
+	if pipes != nil {
+		pipes.feed()
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return failed(id, errorCode(err), fmt.Sprintf("Can't create snapshot %s: %s", id, stderr.lastLine(err)))
+	}
+
+	e, err := snapSnap.DecodeEnvelope(stdout.Bytes())
+	if err != nil {
+		return failed(id, snapCore.SnapErrorOther, fmt.Sprintf("Can't decode snapshot %s: %s", id, err))
+	}
+
+	return result{snap: e}
+}
+
+func failed(id, code, msg string) result {
+	fmt.Fprintf(os.Stderr, "Error: %s\n", msg)
+
+	return result{err: &snapCore.SnapError{BrigadeID: id, Code: code, Message: msg}}
+}
+
+// errorCode returns the failure code for the snapshot tool run error.
+func errorCode(err error) string {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return snapCore.SnapErrorOther
+	}
+
+	switch code := exitErr.ExitCode(); code {
+	case snapPSK.ExitCodeEmptyPSK,
+		snapPSK.ExitCodeInvalidEncoding,
+		snapPSK.ExitCodeInvalidSize,
+		snapPSK.ExitCodeTrailingData:
+		return snapCore.SnapErrorInvalidPSK
+	default:
+		return snapCore.SnapErrorCode(code)
+	}
+}
+
+// tailBuffer keeps the tail of the written data.
+type tailBuffer struct {
+	buf []byte
+	max int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = b.buf[len(b.buf)-b.max:]
+	}
+
+	return len(p), nil
+}
+
+// lastLine returns the last logged message or the error if there is none.
+func (b *tailBuffer) lastLine(err error) string {
+	lines := strings.Split(strings.TrimSpace(string(b.buf)), "\n")
+	if line := logPrefixRe.ReplaceAllString(lines[len(lines)-1], ""); line != "" {
+		return line
+	}
+
+	return err.Error()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+)
+
+// fakeRunner returns the snapshot or the failure of the brigade,
+// the later brigades are finished first to mix the completion order.
+// It counts the brigades run at once.
+type fakeRunner struct {
+	brigades []string
+	failing  map[string]bool
+	running  atomic.Int32
+	maxRun   atomic.Int32
+}
+
+func (f *fakeRunner) run(id string) result {
+	n := f.running.Add(1)
+	defer f.running.Add(-1)
+
+	for {
+		m := f.maxRun.Load()
+		if n <= m || f.maxRun.CompareAndSwap(m, n) {
+			break
+		}
+	}
+
+	time.Sleep(time.Duration(len(f.brigades)-slices.Index(f.brigades, id)) * time.Millisecond)
+
+	if f.failing[id] {
+		return result{err: &snapCore.SnapError{BrigadeID: id, Code: snapCore.SnapErrorOther, Message: "failed"}}
+	}
+
+	return result{snap: &snapCore.EncryptedBrigade{BrigadeID: id}}
+}
+
+func Test_fetchSnaps(t *testing.T) {
+	var brigades []string
+	for i := range 10 {
+		brigades = append(brigades, fmt.Sprintf("brigade%d", i))
+	}
+
+	failing := map[string]bool{"brigade3": true, "brigade7": true}
+
+	for _, workers := range []int{1, 3, 20} {
+		t.Run(fmt.Sprintf("workers %d", workers), func(t *testing.T) {
+			r := &fakeRunner{brigades: brigades, failing: failing}
+			w := &bytes.Buffer{}
+
+			out := &bundleOutput{rw: &recordWriter{w: bufio.NewWriter(w)}, results: make([]result, len(brigades))}
+
+			if err := fetchSnaps(r, brigades, workers, out); err != nil {
+				t.Fatalf("fetchSnaps() error = %v", err)
+			}
+
+			if got := int(r.maxRun.Load()); got > workers {
+				t.Errorf("fetchSnaps() ran %d brigades at once, want at most %d", got, workers)
+			}
+
+			bundle := &snapCore.SnapBundle{}
+			if err := json.Unmarshal(w.Bytes(), bundle); err != nil {
+				t.Fatal(err)
+			}
+
+			var snaps, errs []string
+
+			for _, e := range bundle.Snaps {
+				snaps = append(snaps, e.BrigadeID)
+			}
+
+			for _, e := range bundle.Errors {
+				errs = append(errs, e.BrigadeID)
+			}
+
+			wantSnaps := slices.DeleteFunc(slices.Clone(brigades), func(id string) bool { return failing[id] })
+
+			if !slices.Equal(snaps, wantSnaps) || !slices.Equal(errs, []string{"brigade3", "brigade7"}) {
+				t.Errorf("fetchSnaps() snaps = %v, errors = %v, want %v in the list order", snaps, errs, wantSnaps)
+			}
+
+			if bundle.TotalCount != len(brigades) || bundle.ErrorsCount != len(failing) {
+				t.Errorf("fetchSnaps() totals = %d, %d, want %d, %d", bundle.TotalCount, bundle.ErrorsCount, len(brigades), len(failing))
+			}
+		})
+	}
+}
+
+func Test_fetchSnaps_Stream(t *testing.T) {
+	brigades := []string{"brigade0", "brigade1", "brigade2"}
+
+	r := &fakeRunner{brigades: brigades, failing: map[string]bool{"brigade1": true}}
+	w := &bytes.Buffer{}
+
+	out := &streamOutput{rw: &recordWriter{w: bufio.NewWriter(w)}}
+
+	if err := fetchSnaps(r, brigades, len(brigades), out); err != nil {
+		t.Fatalf("fetchSnaps() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(w.String()), "\n")
+	if len(lines) != len(brigades)+1 {
+		t.Fatalf("fetchSnaps() records = %d, want %d", len(lines), len(brigades)+1)
+	}
+
+	record := &snapCore.SnapRecord{}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), record); err != nil {
+		t.Fatal(err)
+	}
+
+	if record.Type != snapCore.RecordTypeSummary || record.Summary.TotalCount != 3 || record.Summary.ErrorsCount != 1 {
+		t.Errorf("fetchSnaps() summary = %+v, want 3 total and 1 error", record.Summary)
+	}
+}
+
+// failingOutput fails to write the first result.
+type failingOutput struct{ bundleOutput }
+
+var errOutput = errors.New("output failed")
+
+func (o *failingOutput) add(int, result) error {
+	return errOutput
+}
+
+func Test_fetchSnaps_OutputError(t *testing.T) {
+	brigades := []string{"brigade0", "brigade1"}
+
+	if err := fetchSnaps(&fakeRunner{brigades: brigades}, brigades, 1, &failingOutput{}); !errors.Is(err, errOutput) {
+		t.Errorf("fetchSnaps() error = %v, want %v", err, errOutput)
+	}
+}
+
+func Test_tailBuffer_lastLine(t *testing.T) {
+	err := errors.New("exit status 1")
+
+	tests := []struct {
+		name  string
+		write []string
+		max   int
+		want  string
+	}{
+		{name: "empty", want: "exit status 1"},
+		{name: "blank lines", write: []string{"\n\n"}, want: "exit status 1"},
+		{name: "log prefix", write: []string{"2024/01/02 03:04:05 first\n", "2024/01/02 03:04:05 Error: last\n"}, want: "Error: last"},
+		{name: "no prefix", write: []string{"plain message"}, want: "plain message"},
+		{name: "tail", write: []string{strings.Repeat("x", 64) + "\nlast line"}, max: 12, want: "last line"},
+		{name: "split writes", write: []string{"2024/01/02 03:04:05 Err", "or: split\n"}, want: "Error: split"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &tailBuffer{max: MaxErrorMessageSize}
+			if tt.max > 0 {
+				b.max = tt.max
+			}
+
+			for _, s := range tt.write {
+				if n, err := b.Write([]byte(s)); err != nil || n != len(s) {
+					t.Fatalf("Write() = %d, %v, want %d", n, err, len(s))
+				}
+			}
+
+			if len(b.buf) > b.max {
+				t.Errorf("tailBuffer len = %d, want at most %d", len(b.buf), b.max)
+			}
+
+			if got := b.lastLine(err); got != tt.want {
+				t.Errorf("lastLine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	snapDelta "github.com/vpngen/keydesk-snap/core/delta"
+	snapSnap "github.com/vpngen/keydesk-snap/core/snap"
+)
+
+var (
+	ErrNotFullSnapshot  = fmt.Errorf("not a full brigade snapshot")
+	ErrNotDelta         = fmt.Errorf("not an incremental snapshot")
+	ErrDeltaBrigade     = fmt.Errorf("incremental snapshot of another brigade")
+	ErrDeltaBase        = fmt.Errorf("incremental snapshot base mismatch")
+	ErrDeltaNeedsMaster = fmt.Errorf("incremental snapshots chain requires master PSK")
+)
+
+// replayDeltas opens the full snapshot and applies the chain
+// of the incremental snapshots on top of it in the given order.
+// The result is the canonical brigade JSON with the sorted keys indented
+// with the keydesk indent, so it has the content of the brigade file,
+// but not its byte layout.
+func replayDeltas(opts *CommandOpts, e *snapshot, psk []byte) ([]byte, error) {
+	if !opts.MasterPSK {
+		return nil, ErrDeltaNeedsMaster
+	}
+
+	if e.PayloadFormat != "" {
+		return nil, fmt.Errorf("%w: %s: %s", ErrNotFullSnapshot, e.Tag, e.PayloadFormat)
+	}
+
+	data, err := openSnapshot(opts, e, psk)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", e.Tag, err)
+	}
+
+	state, err := snapDelta.Canonical(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: canonical: %w", e.Tag, err)
+	}
+
+	tag, digest := e.Tag, snapDelta.Digest(state)
+
+	for _, filename := range opts.DeltaFiles {
+		d, err := readSnapshot(opts, filename)
+		if err != nil {
+			return nil, fmt.Errorf("read delta %s: %w", filename, err)
+		}
+
+		switch {
+		case d.PayloadFormat != snapCore.PayloadFormatJSONPatch:
+			return nil, fmt.Errorf("%w: %s", ErrNotDelta, d.Tag)
+		case d.BrigadeID != e.BrigadeID:
+			return nil, fmt.Errorf("%w: %s: %s", ErrDeltaBrigade, d.Tag, d.BrigadeID)
+		case d.BaseTag != tag || d.BaseDigest != digest:
+			return nil, fmt.Errorf("%w: %s: base %s, want %s", ErrDeltaBase, d.Tag, d.BaseTag, tag)
+		}
+
+		payload, err := openSnapshot(opts, d, psk)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", d.Tag, err)
+		}
+
+		patch := &snapDelta.Patch{}
+		if err := json.Unmarshal(payload, patch); err != nil {
+			return nil, fmt.Errorf("%s: unmarshal patch: %w", d.Tag, err)
+		}
+
+		if state, err = snapDelta.Apply(state, patch); err != nil {
+			return nil, fmt.Errorf("%s: apply: %w", d.Tag, err)
+		}
+
+		tag, digest = d.Tag, patch.ResultDigest
+	}
+
+	out := &bytes.Buffer{}
+	if err := json.Indent(out, state, " ", " "); err != nil {
+		return nil, fmt.Errorf("indent: %w", err)
+	}
+
+	out.WriteByte('\n')
+
+	return out.Bytes(), nil
+}
+
+// openSnapshot decrypts the snapshot payload in memory.
+func openSnapshot(opts *CommandOpts, s *snapshot, psk []byte) ([]byte, error) {
+	ropts, err := restoreOpts(opts, s, psk)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := snapSnap.OpenSnapshot(s.EncryptedBrigade, ropts)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+
+	return data, nil
+}
+
+// writeReplayed is an adapter of the replayed brigade for writeOutput.
+func writeReplayed(data []byte) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := w.Write(data)
+
+		return err
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	snapArchive "github.com/vpngen/keydesk-snap/core/archive"
+	snapCrypto "github.com/vpngen/keydesk-snap/core/crypto"
+	snapHelper "github.com/vpngen/keydesk-snap/core/helper"
+	snapPSK "github.com/vpngen/keydesk-snap/core/psk"
+	snapSnap "github.com/vpngen/keydesk-snap/core/snap"
+	"github.com/vpngen/keydesk/keydesk/storage"
+)
+
+// MaxSnapshotFileSize is a maximum snapshot file size in KB.
+const MaxSnapshotFileSize = 1024 * 1024 // 1 GB
+
+var (
+	ErrEmptySnapshotFile = fmt.Errorf("empty snapshot file")
+	ErrEmptyRealmKey     = fmt.Errorf("empty realm key file")
+	ErrEmptyAuthKey      = fmt.Errorf("empty authority key file")
+	ErrEmptyOutputDir    = fmt.Errorf("empty output dir for archive payload")
+)
+
+type CommandOpts struct {
+	SnapshotFile string
+	OutputFile   string
+	RealmKeyFile string
+	AuthKeyFile  string
+	MasterPSK    bool
+	DerivePSK    bool
+	PSKSource    snapPSK.Source
+	DeltaFiles   []string
+	// StorageVersion is a target keydesk storage version.
+	StorageVersion int
+	// PayloadDir is a dir of the detached payload files.
+	PayloadDir string
+	// SpoolDir is a temporary dir of the inline payloads.
+	SpoolDir string
+	// Overwrite allows to overwrite the existing files of the archive payload.
+	Overwrite bool
+}
+
+func main() {
+	opts, err := parseArgs()
+	if err != nil {
+		log.Fatalf("Invalid flags: %s\n", err)
+	}
+
+	psk, err := snapPSK.Read(opts.PSKSource)
+	if err != nil {
+		log.Printf("Read PSK: %s\n", err)
+		os.Exit(snapPSK.ExitCode(err))
+	}
+
+	if err := restore(opts, psk); err != nil {
+		log.Fatalf("Restore: %s\n", err)
+	}
+}
+
+// restore restores the snapshot, the inline payloads
+// are spooled to the temporary dir removed on return.
+func restore(opts *CommandOpts, psk []byte) error {
+	spool, err := os.MkdirTemp("", "restore-")
+	if err != nil {
+		return fmt.Errorf("spool dir: %w", err)
+	}
+
+	defer os.RemoveAll(spool)
+
+	opts.SpoolDir = spool
+
+	e, err := readSnapshot(opts, opts.SnapshotFile)
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+
+	if opts.DerivePSK {
+		brigadePSK, err := snapSnap.DerivedPSK(e.EncryptedBrigade, psk)
+		if err != nil {
+			return fmt.Errorf("derive PSK: %w", err)
+		}
+
+		fmt.Println(base64.StdEncoding.EncodeToString(brigadePSK))
+
+		return nil
+	}
+
+	id, err := snapSnap.SnapshotID(e.EncryptedBrigade)
+	if err != nil {
+		return fmt.Errorf("snapshot ID: %w", err)
+	}
+
+	log.Printf("Snapshot ID: %s\n", id)
+
+	if err := checkStorageVersion(e.EncryptedBrigade, opts.StorageVersion); err != nil {
+		return fmt.Errorf("check storage version: %w", err)
+	}
+
+	if e.RedactionPolicy != "" {
+		log.Printf("Snapshot payload is redacted, policy digest: %s\n", e.RedactionPolicy)
+	}
+
+	if len(opts.DeltaFiles) > 0 || e.PayloadFormat == snapCore.PayloadFormatJSONPatch {
+		data, err := replayDeltas(opts, e, psk)
+		if err != nil {
+			return fmt.Errorf("replay incremental snapshots: %w", err)
+		}
+
+		if err := writeOutput(opts.OutputFile, writeReplayed(data)); err != nil {
+			return fmt.Errorf("write output: %w", err)
+		}
+
+		return nil
+	}
+
+	ropts, err := restoreOpts(opts, e, psk)
+	if err != nil {
+		return fmt.Errorf("restore snapshot: %w", err)
+	}
+
+	open := func(w io.Writer) error {
+		return snapSnap.OpenSnapshotTo(w, e.EncryptedBrigade, ropts)
+	}
+
+	if e.PayloadFormat == snapCore.PayloadFormatTar {
+		if err := unpackOutput(opts.OutputFile, opts.Overwrite, open); err != nil {
+			return fmt.Errorf("unpack output: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := writeOutput(opts.OutputFile, open); err != nil {
+		return fmt.Errorf("write output: %w", err)
+	}
+
+	return nil
+}
+
+// snapshot is the read envelope and the dir of its payload file.
+type snapshot struct {
+	*snapCore.EncryptedBrigade
+	payloadDir string
+}
+
+// readSnapshot reads the envelope without keeping the payload in memory,
+// the inline payload is spooled to the spool dir.
+func readSnapshot(opts *CommandOpts, filename string) (*snapshot, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+
+	if fi.Size() > MaxSnapshotFileSize*1024 {
+		return nil, fmt.Errorf("%w: %d", snapHelper.ErrFileTooBig, fi.Size())
+	}
+
+	e, spooled, err := snapSnap.ReadEnvelope(f, opts.SpoolDir)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+
+	if err := checkTag(e); err != nil {
+		return nil, err
+	}
+
+	s := &snapshot{EncryptedBrigade: e, payloadDir: opts.PayloadDir}
+	if spooled {
+		s.payloadDir = opts.SpoolDir
+	}
+
+	return s, nil
+}
+
+// checkTag checks the tag grammar and the tag time.
+// The older envelopes can have the free form tags, they are only reported.
+func checkTag(e *snapCore.EncryptedBrigade) error {
+	tag, err := snapCore.ParseTag(e.Tag)
+	if err == nil {
+		err = tag.CheckTime(e.GlobalSnapAt)
+	}
+
+	if err != nil && e.Version < snapCore.TagGrammarEnvelopeVersion {
+		log.Printf("Warning: %s\n", err)
+
+		return nil
+	}
+
+	return err
+}
+
+// restoreOpts decrypts the snapshot secrets with the private keys.
+func restoreOpts(opts *CommandOpts, s *snapshot, psk []byte) (snapSnap.RestoreOpts, error) {
+	e := s.EncryptedBrigade
+
+	realmKey, err := snapCrypto.ReadPrivateSSHKeyFile(opts.RealmKeyFile)
+	if err != nil {
+		return snapSnap.RestoreOpts{}, fmt.Errorf("read realm key: %w", err)
+	}
+
+	authKey, err := snapCrypto.ReadPrivateSSHKeyFile(opts.AuthKeyFile)
+	if err != nil {
+		return snapSnap.RestoreOpts{}, fmt.Errorf("read authority key: %w", err)
+	}
+
+	locker, err := snapSnap.DecryptLockerSecret(e, realmKey)
+	if err != nil {
+		return snapSnap.RestoreOpts{}, fmt.Errorf("decrypt: %w", err)
+	}
+
+	secret, err := snapSnap.DecryptAuthoritySecret(e, authKey)
+	if err != nil {
+		return snapSnap.RestoreOpts{}, fmt.Errorf("decrypt: %w", err)
+	}
+
+	ropts := snapSnap.RestoreOpts{
+		LockerSecret: locker,
+		Secret:       secret,
+		PayloadDir:   s.payloadDir,
+	}
+
+	if opts.MasterPSK {
+		ropts.MasterPSK = psk
+	} else {
+		ropts.PSK = psk
+	}
+
+	return ropts, nil
+}
+
+// writeOutput writes the payload to the stdout or to the file.
+// The payload digest is verified before anything is written:
+// the payload is spooled to the temporary file first, which is
+// copied to the stdout or renamed to the file on success.
+func writeOutput(filename string, open func(w io.Writer) error) error {
+	dir := filepath.Dir(filename)
+	if filename == "" {
+		dir = ""
+	}
+
+	f, err := spoolPayload(dir, open)
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if filename == "" {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("seek: %w", err)
+		}
+
+		if _, err := io.Copy(os.Stdout, f); err != nil {
+			return fmt.Errorf("write: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), filename); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+
+	return nil
+}
+
+// unpackOutput unpacks the archive payload into the dir
+// after the payload digest is verified.
+func unpackOutput(dir string, overwrite bool, open func(w io.Writer) error) error {
+	if dir == "" {
+		return ErrEmptyOutputDir
+	}
+
+	f, err := spoolPayload(dir, open)
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seek: %w", err)
+	}
+
+	manifest, err := snapArchive.Unpack(bufio.NewReaderSize(f, snapSnap.StreamBufferSize), dir, overwrite)
+	if err != nil {
+		return fmt.Errorf("unpack: %w", err)
+	}
+
+	for _, fi := range manifest.Files {
+		fmt.Fprintf(os.Stderr, "%s %s %d %s\n", fi.Mode, fi.SHA256, fi.Size, fi.Name)
+	}
+
+	return nil
+}
+
+// spoolPayload writes the payload to the temporary file in the dir,
+// the default temporary dir is used if the dir is empty.
+// The file is accessible by the owner only.
+// The caller must close and remove the file.
+func spoolPayload(dir string, open func(w io.Writer) error) (*os.File, error) {
+	f, err := os.CreateTemp(dir, ".restore-")
+	if err != nil {
+		return nil, fmt.Errorf("create temp: %w", err)
+	}
+
+	w := bufio.NewWriterSize(f, snapSnap.StreamBufferSize)
+
+	if err := open(w); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+
+		return nil, fmt.Errorf("open: %w", err)
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+
+		return nil, fmt.Errorf("write: %w", err)
+	}
+
+	return f, nil
+}
+
+func parseArgs() (*CommandOpts, error) {
+	snapFile := flag.String("i", "", "Snapshot file")
+	outFile := flag.String("o", "", "Output file (output dir for archive payload). Default: stdout")
+	realmKey := flag.String("rkey", "", "Realm private key file")
+	authKey := flag.String("akey", "", "Authority private key file")
+	master := flag.Bool("master", false, "PSK is a master PSK, derive the brigade PSK from it")
+	derive := flag.Bool("derive", false, "Print the brigade PSK derived from the master PSK and exit, the snapshot must be made with the PSK derivation")
+	pskFD := flag.Int("psk-fd", 0, "Read PSK from the file descriptor. Default: stdin")
+	pskFile := flag.String("psk-file", "", "Read PSK from the file accessible by the owner only. Default: stdin")
+	pskEnv := flag.String("psk-env", "", "Read PSK from the environment variable. Default: stdin")
+
+	storageVersion := flag.Int("storage-version", storage.BrigadeVersion, "Keydesk storage version of the target host, the newer snapshots are refused")
+
+	payloadDir := flag.String("payload-dir", "", "Dir of the detached payload files. Default: the snapshot file dir")
+	overwrite := flag.Bool("force", false, "Overwrite the existing files of the archive payload in the output dir")
+
+	var deltaFiles []string
+
+	flag.Func("delta", "Incremental snapshot file to apply on top of the snapshot, repeat in the chain order", func(s string) error {
+		deltaFiles = append(deltaFiles, s)
+
+		return nil
+	})
+
+	flag.Parse()
+
+	if *snapFile == "" {
+		return nil, ErrEmptySnapshotFile
+	}
+
+	opts := &CommandOpts{
+		OutputFile: *outFile,
+		MasterPSK:  *master,
+		DerivePSK:  *derive,
+		DeltaFiles: deltaFiles,
+		Overwrite:  *overwrite,
+
+		StorageVersion: *storageVersion,
+		PSKSource: snapPSK.Source{
+			FD:   *pskFD,
+			File: *pskFile,
+			Env:  *pskEnv,
+		},
+	}
+
+	var err error
+
+	opts.SnapshotFile, err = filepath.Abs(*snapFile)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot file: %w", err)
+	}
+
+	opts.PayloadDir = filepath.Dir(opts.SnapshotFile)
+	if *payloadDir != "" {
+		opts.PayloadDir, err = filepath.Abs(*payloadDir)
+		if err != nil {
+			return nil, fmt.Errorf("payload dir: %w", err)
+		}
+	}
+
+	if opts.DerivePSK {
+		return opts, nil
+	}
+
+	if *realmKey == "" {
+		return nil, ErrEmptyRealmKey
+	}
+
+	if *authKey == "" {
+		return nil, ErrEmptyAuthKey
+	}
+
+	opts.RealmKeyFile = *realmKey
+	opts.AuthKeyFile = *authKey
+
+	return opts, nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+)
+
+var ErrNewerStorageVersion = fmt.Errorf("snapshot storage version is newer than target")
+
+// checkStorageVersion refuses the snapshot before the decryption
+// if its storage version is newer than the target one.
+// The older brigades are restored as is: keydesk sets the version
+// only when it creates the brigade and reads the older ones itself.
+// There is no migration registry here on purpose, the keydesk storage
+// upgrade on load (the Ver < 7 endpoint port default) is the only
+// migration path, the restore never rewrites the brigade version.
+func checkStorageVersion(e *snapCore.EncryptedBrigade, target int) error {
+	if e.StorageVersion > target {
+		return fmt.Errorf("%w: %d > %d, snapshot keydesk %s", ErrNewerStorageVersion, e.StorageVersion, target, e.KeydeskVersion)
+	}
+
+	return nil
+}
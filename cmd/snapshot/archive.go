@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	snapArchive "github.com/vpngen/keydesk-snap/core/archive"
+	snapHelper "github.com/vpngen/keydesk-snap/core/helper"
+	"github.com/vpngen/keydesk/keydesk/storage"
+)
+
+// ArchiveFilesFileName is a name of the allowlist file in the config dir.
+// One file name per line, empty lines and # comments are ignored.
+const ArchiveFilesFileName = "archive_files"
+
+// DefaultArchiveFiles is a list of files archived
+// if there is no allowlist file in the config dir.
+var DefaultArchiveFiles = []string{storage.BrigadeFilename, MaintenanceFileName}
+
+// readArchiveFiles reads the allowlist of the brigade dir files.
+func readArchiveFiles(etcDir string) ([]string, error) {
+	data, err := snapHelper.ReadFileSafeSize(filepath.Join(etcDir, ArchiveFilesFileName), snapCore.MaxKeysFileSize)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return DefaultArchiveFiles, nil
+		}
+
+		return nil, fmt.Errorf("read allowlist: %w", err)
+	}
+
+	files := []string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		files = append(files, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan allowlist: %w", err)
+	}
+
+	return files, nil
+}
+
+// packBrigadeDir returns a reader of the tar stream with the brigade.json
+// from the brigade reader and the allowlisted files from the dir.
+// Missing allowlisted files are skipped.
+func packBrigadeDir(dir string, files []string, brigade io.Reader, brigadeInfo fs.FileInfo) io.Reader {
+	pr, pw := io.Pipe()
+
+	go func() {
+		w := snapArchive.NewWriter(pw)
+
+		if err := w.AddFile(storage.BrigadeFilename, brigadeInfo.Mode(), brigadeInfo.Size(), brigade); err != nil {
+			pw.CloseWithError(fmt.Errorf("add %s: %w", storage.BrigadeFilename, err))
+
+			return
+		}
+
+		for _, name := range files {
+			if name == storage.BrigadeFilename {
+				continue
+			}
+
+			if err := w.AddFileFromDir(dir, name); err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
+
+				pw.CloseWithError(fmt.Errorf("add %s: %w", name, err))
+
+				return
+			}
+		}
+
+		pw.CloseWithError(w.Close())
+	}()
+
+	return pr
+}
+
+// resizedFileInfo is a brigade file info with the size of the redacted brigade.
+type resizedFileInfo struct {
+	fs.FileInfo
+
+	size int64
+}
+
+func (fi resizedFileInfo) Size() int64 {
+	return fi.size
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	snapCrypto "github.com/vpngen/keydesk-snap/core/crypto"
+	snapDelta "github.com/vpngen/keydesk-snap/core/delta"
+	snapHelper "github.com/vpngen/keydesk-snap/core/helper"
+	snapSnap "github.com/vpngen/keydesk-snap/core/snap"
+)
+
+var (
+	ErrIncrementalArchive = fmt.Errorf("incremental mode is not supported for archive")
+	ErrReference          = fmt.Errorf("unusable reference, make the full snapshot with -rebase")
+)
+
+// The reference of the just made snapshot is kept pending until
+// the realm confirms the delivery by passing its tag as -base
+// to the next run. Only the confirmed reference is the delta base.
+
+// referenceKey returns the key of the brigade references. It is derived
+// from the host key, so it doesn't change with the PSK. The supervisor
+// passes the derived key, the host key is not readable by the brigade.
+func referenceKey(opts *CommandOpts) ([]byte, error) {
+	if opts.ReferenceKeyFD > 0 {
+		f, err := snapHelper.OpenFD(opts.ReferenceKeyFD, "reference-key-fd")
+		if err != nil {
+			if errors.Is(err, syscall.EBADF) {
+				return nil, fmt.Errorf("%w: %w", ErrReferenceKeyFD, err)
+			}
+
+			return nil, err
+		}
+
+		defer f.Close()
+
+		key, err := io.ReadAll(io.LimitReader(f, snapDelta.ReferenceKeySize+1))
+		if err != nil {
+			return nil, fmt.Errorf("read: %w", err)
+		}
+
+		if len(key) != snapDelta.ReferenceKeySize {
+			return nil, fmt.Errorf("%w: size %d", snapDelta.ErrReferenceKey, len(key))
+		}
+
+		return key, nil
+	}
+
+	hostKey, err := snapDelta.ReadReferenceKey(filepath.Join(opts.EtcDir, snapDelta.ReferenceKeyFileName))
+	if err != nil {
+		return nil, fmt.Errorf("host key: %w", err)
+	}
+
+	key, err := snapCrypto.DeriveReferenceKey(hostKey, opts.BrigadeID)
+	if err != nil {
+		return nil, fmt.Errorf("derive: %w", err)
+	}
+
+	return key, nil
+}
+
+// incrementalPayload returns the delta of the brigade against the local
+// confirmed reference and sets the base snapshot in the snapshot options.
+// The brigade is returned as is if there is no confirmed reference yet.
+func incrementalPayload(data []byte, opts *CommandOpts, key []byte, sopts *snapSnap.SnapOpts) ([]byte, error) {
+	if opts.Rebase {
+		return data, nil
+	}
+
+	filename := filepath.Join(opts.DbDir, snapDelta.ReferenceFileName)
+
+	if opts.BaseTag != "" {
+		if err := confirmReference(filename, key, opts.BaseTag); err != nil {
+			return nil, fmt.Errorf("confirm reference: %w", err)
+		}
+	}
+
+	ref, err := snapDelta.ReadReference(filename, key)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			log.Printf("No confirmed reference, make full snapshot\n")
+
+			return data, nil
+		}
+
+		return nil, fmt.Errorf("%w: %w", ErrReference, err)
+	}
+
+	patch, err := snapDelta.Diff(ref.Data, data)
+	if err != nil {
+		return nil, fmt.Errorf("diff: %w", err)
+	}
+
+	payload, err := json.Marshal(patch)
+	if err != nil {
+		return nil, fmt.Errorf("marshal patch: %w", err)
+	}
+
+	sopts.PayloadFormat = snapCore.PayloadFormatJSONPatch
+	sopts.BaseTag = ref.Tag
+	sopts.BaseDigest = ref.Digest
+
+	return payload, nil
+}
+
+// confirmReference makes the pending reference the confirmed one
+// if it is of the snapshot delivered to the realm.
+func confirmReference(filename string, key []byte, base string) error {
+	pending := filename + snapDelta.PendingReferenceSuffix
+
+	ref, err := snapDelta.ReadReference(pending, key)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+
+		return fmt.Errorf("%w: %w", ErrReference, err)
+	}
+
+	if ref.Tag != base {
+		log.Printf("Pending reference %s is not delivered, realm base %s\n", ref.Tag, base)
+
+		return nil
+	}
+
+	if err := os.Rename(pending, filename); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+
+	return nil
+}
+
+// updateReference writes the brigade of the just made snapshot as the
+// pending reference, the confirmed one is kept until the delivery is confirmed.
+func updateReference(data []byte, opts *CommandOpts, key []byte) error {
+	ref, err := snapDelta.NewReference(opts.Tag, data)
+	if err != nil {
+		return fmt.Errorf("reference: %w", err)
+	}
+
+	filename := filepath.Join(opts.DbDir, snapDelta.ReferenceFileName+snapDelta.PendingReferenceSuffix)
+
+	if err := snapDelta.WriteReference(filename, key, ref); err != nil {
+		return fmt.Errorf("write reference: %w", err)
+	}
+
+	return nil
+}
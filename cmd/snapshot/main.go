@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/rsa"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"os/user"
@@ -13,11 +18,16 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	snapCore "github.com/vpngen/keydesk-snap/core"
 	snapCrypto "github.com/vpngen/keydesk-snap/core/crypto"
+	snapDelta "github.com/vpngen/keydesk-snap/core/delta"
+	snapHelper "github.com/vpngen/keydesk-snap/core/helper"
+	snapPSK "github.com/vpngen/keydesk-snap/core/psk"
 	snapSnap "github.com/vpngen/keydesk-snap/core/snap"
+	snapValidate "github.com/vpngen/keydesk-snap/core/validate"
 	"github.com/vpngen/keydesk/kdlib/lockedfile"
 	"github.com/vpngen/keydesk/keydesk/storage"
 )
@@ -25,6 +35,11 @@ import (
 const (
 	DefaultSnapEtcDir   = "/etc/vg-keydesk-snap"
 	MaintenanceFileName = ".maintenance"
+	// StorageVersionField is a name of the brigade storage version field.
+	StorageVersionField = "version"
+
+	// DefaultLockTimeout is a default maximum time to wait for the brigade file lock.
+	DefaultLockTimeout = time.Minute
 )
 
 var (
@@ -32,52 +47,137 @@ var (
 	ErrEmptyRealmFP   = fmt.Errorf("empty realm fingerprint")
 	ErrInvalidRealmFP = fmt.Errorf("invalid realm fingerprint")
 	ErrInvalidTime    = fmt.Errorf("invalid time")
+
+	ErrUnknownCompression = fmt.Errorf("unknown compression")
+	ErrStorageVersion     = fmt.Errorf("unsupported storage version")
+	ErrIntegrity          = fmt.Errorf("brigade integrity mismatch")
+	ErrLockTimeout        = fmt.Errorf("brigade lock timeout")
+	ErrKeysFD             = fmt.Errorf("key material fd not found")
+	ErrReferenceKeyFD     = fmt.Errorf("reference key fd not found")
 )
 
 type CommandOpts struct {
-	BrigadeID    string
-	EtcDir       string
-	DbDir        string
-	RealmFP      string
-	Tag          string
-	GlobalSnapAt time.Time
-	Maintenance  int64
+	BrigadeID        string
+	EtcDir           string
+	DbDir            string
+	RealmFP          string
+	Tag              string
+	GlobalSnapAt     time.Time
+	Maintenance      int64
+	PSKSource        snapPSK.Source
+	CheckPSK         bool
+	Archive          bool
+	Compression      string
+	CompressionLevel int
+	Padding          snapSnap.Padding
+	Incremental      bool
+	Rebase           bool
+	BaseTag          string
+	Validate         string
+	Encoding         string
+	DetachedDir      string
+	Meta             bool
+	LockTimeout      time.Duration
+	// KeysFD is a file descriptor of the key material
+	// from the supervisor. Zero means the keys files.
+	KeysFD int
+	// ReferenceKeyFD is a file descriptor of the brigade reference key
+	// from the supervisor. Zero means the host reference key file.
+	ReferenceKeyFD int
 }
 
 func main() {
-	var w io.WriteCloser
-
 	opts, err := parseArgs()
 	if err != nil {
 		log.Fatalf("Invalid flags: %s\n", err)
 	}
 
-	psk, err := readPSK()
+	psk, err := snapPSK.Read(opts.PSKSource)
 	if err != nil {
-		log.Fatalf("Read PSK: %s\n", err)
+		log.Printf("Read PSK: %s\n", err)
+		os.Exit(snapPSK.ExitCode(err))
 	}
 
-	w = os.Stdout
+	if opts.CheckPSK {
+		return
+	}
+
+	// the snapshot is streamed, so the output must be
+	// discarded by the caller if the exit code is not zero
+	w := bufio.NewWriterSize(os.Stdout, snapSnap.StreamBufferSize)
 
-	data, err := getSnapshot(opts, psk)
+	id, err := getSnapshot(w, opts, psk)
 	if err != nil {
-		log.Fatalf("Get snapshot: %s", err)
+		log.Printf("Get snapshot: %s\n", err)
+		os.Exit(exitCode(err))
 	}
 
-	if _, err := w.Write(data); err != nil {
+	if err := w.Flush(); err != nil {
 		log.Fatalf("Write snapshot: %s", err)
 	}
+
+	log.Printf("Snapshot ID: %s\n", id)
+}
+
+// exitCode returns the process exit code for the snapshot error,
+// the fetch reports it to the realm as the failure code.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, snapCrypto.ErrKeyNotFound),
+		errors.Is(err, ErrKeysFD),
+		errors.Is(err, ErrReferenceKeyFD):
+		return snapCore.ExitCodeKeyNotFound
+	case errors.Is(err, ErrIntegrity),
+		errors.Is(err, storage.ErrWrongStorageConfiguration):
+		return snapCore.ExitCodeIntegrity
+	case errors.Is(err, snapValidate.ErrInvalid):
+		return snapCore.ExitCodeValidationFailed
+	case errors.Is(err, ErrStorageVersion):
+		return snapCore.ExitCodeUnsupportedStorageVersion
+	case errors.Is(err, ErrLockTimeout):
+		return snapCore.ExitCodeLockTimeout
+	case errors.Is(err, fs.ErrPermission):
+		return snapCore.ExitCodePermissionDenied
+	default:
+		return 1
+	}
 }
 
-func readPSK() ([]byte, error) {
-	r := base64.NewDecoder(base64.StdEncoding, os.Stdin)
+// openLocked opens the file with the read lock waiting no longer
+// than the timeout. Zero timeout means no limit.
+func openLocked(filename string, timeout time.Duration) (*lockedfile.File, error) {
+	if timeout == 0 {
+		return lockedfile.OpenFile(filename, os.O_RDONLY, 0o644)
+	}
 
-	psk, err := io.ReadAll(io.LimitReader(r, snapCore.PSKSize))
-	if err != nil {
-		return nil, fmt.Errorf("read: %w", err)
+	type result struct {
+		f   *lockedfile.File
+		err error
 	}
 
-	return psk, nil
+	ch := make(chan result, 1)
+
+	go func() {
+		f, err := lockedfile.OpenFile(filename, os.O_RDONLY, 0o644)
+		ch <- result{f: f, err: err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-ch:
+		return res.f, res.err
+	case <-timer.C:
+		// release the lock if it is got too late
+		go func() {
+			if res := <-ch; res.f != nil {
+				res.f.Close()
+			}
+		}()
+
+		return nil, fmt.Errorf("%w: %s", ErrLockTimeout, timeout)
+	}
 }
 
 func writeMaintenanceFile(dir string, maintenance int64) error {
@@ -99,68 +199,148 @@ func writeMaintenanceFile(dir string, maintenance int64) error {
 	return nil
 }
 
-func getSnapshot(opts *CommandOpts, psk []byte) ([]byte, error) {
+// readMaintenanceFile returns the maintenance end time, zero if there is no maintenance.
+func readMaintenanceFile(dir string) (int64, error) {
+	data, err := snapHelper.ReadFileSafeSize(filepath.Join(dir, MaintenanceFileName), 1)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	maintenance, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse: %w", err)
+	}
+
+	return maintenance, nil
+}
+
+// brigadeMeta returns the public metadata of the brigade.
+func brigadeMeta(data *storage.Brigade, dir, keydeskVersion string) (*snapCore.SnapshotMeta, error) {
+	maintenance, err := readMaintenanceFile(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read maintenance file: %w", err)
+	}
+
+	return &snapCore.SnapshotMeta{
+		Users:           len(data.Users),
+		KeydeskVersion:  keydeskVersion,
+		MaintenanceTill: maintenance,
+	}, nil
+}
+
+// getSnapshot writes the snapshot and returns its ID.
+func getSnapshot(w io.Writer, opts *CommandOpts, psk []byte) (string, error) {
 	if opts == nil {
-		return nil, fmt.Errorf("empty options")
+		return "", fmt.Errorf("empty options")
 	}
 
 	if opts.Maintenance != 0 {
 		if err := writeMaintenanceFile(opts.DbDir, opts.Maintenance); err != nil {
-			return nil, fmt.Errorf("write maintenance file: %w", err)
+			return "", fmt.Errorf("write maintenance file: %w", err)
 		}
 	}
 
-	realmKey, err := snapCrypto.FindPubKeyInFile(filepath.Join(opts.EtcDir, snapCrypto.DefaultRealmsKeysFileName), opts.RealmFP)
+	realmKey, authKeys, err := readKeys(opts)
 	if err != nil {
-		return nil, fmt.Errorf("find realm key: %w", err)
+		return "", err
 	}
 
-	authKeys, err := snapCrypto.ReadAuthoritiesPubKeyFile(opts.EtcDir)
+	policy, err := readRedactionPolicy(opts.EtcDir)
 	if err != nil {
-		return nil, fmt.Errorf("read authorities keys: %w", err)
+		return "", fmt.Errorf("redaction policy: %w", err)
 	}
 
 	data := &storage.Brigade{}
 	filename := filepath.Join(opts.DbDir, storage.BrigadeFilename)
 
-	f, err := lockedfile.OpenFile(filename, os.O_RDONLY, 0o644)
+	f, err := openLocked(filename, opts.LockTimeout)
 	if err != nil {
-		return nil, fmt.Errorf("open: %w", err)
+		return "", fmt.Errorf("open: %w", err)
 	}
 
 	defer f.Close()
 
 	var (
-		errIntegrity  error
-		encriptedSnap []byte
+		archiveFiles  []string
+		brigadeInfo   fs.FileInfo
+		payloadFormat string
+	)
+
+	if opts.Archive {
+		archiveFiles, err = readArchiveFiles(opts.EtcDir)
+		if err != nil {
+			return "", fmt.Errorf("archive files: %w", err)
+		}
+
+		brigadeInfo, err = f.Stat()
+		if err != nil {
+			return "", fmt.Errorf("stat: %w", err)
+		}
+
+		payloadFormat = snapCore.PayloadFormatTar
+	}
+
+	var (
+		errIntegrity error
 
 		wg = &sync.WaitGroup{}
+
+		// the streamed brigade is validated only after the snapshot is written,
+		// so the validation which can refuse the snapshot is not streamed
+		streamed = !opts.Incremental && policy == nil && opts.Validate != snapValidate.StrictnessStrict
 	)
 
-	if err := func() error {
-		var err error
+	var id string
+
+	// the header is written before the streamed brigade is decoded
+	storageVer := 0
+	if streamed {
+		if storageVer, err = scanStorageVersion(f); err != nil {
+			return "", fmt.Errorf("storage version: %w", err)
+		}
+
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", fmt.Errorf("seek: %w", err)
+		}
+	}
 
+	if err := func() error {
 		pr, pw := io.Pipe()
 		defer pw.CloseWithError(io.EOF)
 
-		rt := io.TeeReader(f, pw)
+		var rt io.Reader = io.TeeReader(f, pw)
 
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 
-			if errIntegrity = json.NewDecoder(pr).Decode(data); errIntegrity != nil {
+			// unblock the tee if the decoder stops early
+			defer io.Copy(io.Discard, pr)
+
+			if err := json.NewDecoder(pr).Decode(data); err != nil {
+				errIntegrity = fmt.Errorf("%w: %w", ErrIntegrity, err)
+
 				return
 			}
 
 			if data.BrigadeID != opts.BrigadeID {
-				errIntegrity = fmt.Errorf("%w: brigade id: %s", storage.ErrWrongStorageConfiguration, opts.BrigadeID)
+				errIntegrity = fmt.Errorf("%w: %w: brigade id: %s", ErrIntegrity, storage.ErrWrongStorageConfiguration, opts.BrigadeID)
+
+				return
+			}
+
+			if data.Ver <= 0 {
+				errIntegrity = fmt.Errorf("%w: %d", ErrStorageVersion, data.Ver)
 
 				return
 			}
 		}()
 
-		encriptedSnap, err = snapSnap.MakeSnapshot(rt, snapSnap.SnapOpts{
+		snapOpts := snapSnap.SnapOpts{
 			Tag:          opts.Tag,
 			BrigadeID:    opts.BrigadeID,
 			GlobalSnapAt: opts.GlobalSnapAt,
@@ -168,23 +348,236 @@ func getSnapshot(opts *CommandOpts, psk []byte) ([]byte, error) {
 			RealFP:       opts.RealmFP,
 			RealmKey:     realmKey,
 			AuthKeys:     authKeys,
-		})
+
+			PSKDerivation: snapCore.PSKDerivationHKDFSHA256,
+			PayloadFormat: payloadFormat,
+
+			Compression:      opts.Compression,
+			CompressionLevel: opts.CompressionLevel,
+			DigestHMAC:       true,
+			Padding:          opts.Padding,
+
+			Encoding:    opts.Encoding,
+			DetachedDir: opts.DetachedDir,
+
+			StorageVersion: storageVer,
+			KeydeskVersion: snapHelper.ModuleVersion(snapHelper.KeydeskModulePath),
+		}
+
+		if opts.Meta {
+			// the payload is streamed when the meta is asked,
+			// so the decoder has got all the brigade
+			snapOpts.Meta = func() (*snapCore.SnapshotMeta, error) {
+				pw.CloseWithError(io.EOF)
+				wg.Wait()
+
+				if errIntegrity != nil {
+					return nil, fmt.Errorf("decode: %w", errIntegrity)
+				}
+
+				return brigadeMeta(data, opts.DbDir, snapOpts.KeydeskVersion)
+			}
+		}
+
+		if streamed {
+			if opts.Archive {
+				rt = packBrigadeDir(opts.DbDir, archiveFiles, rt, brigadeInfo)
+			}
+
+			if id, err = snapSnap.MakeSnapshotIDTo(w, rt, snapOpts); err != nil {
+				return fmt.Errorf("snapshot: %w", err)
+			}
+
+			return nil
+		}
+
+		// the delta, the redaction and the strict validation
+		// need the whole brigade, check it first
+		brigade, err := io.ReadAll(rt)
 		if err != nil {
+			return fmt.Errorf("read: %w", err)
+		}
+
+		pw.CloseWithError(io.EOF)
+		wg.Wait()
+
+		if errIntegrity != nil {
+			return fmt.Errorf("decode: %w", errIntegrity)
+		}
+
+		if err := validateBrigade(data, opts.Validate); err != nil {
+			return fmt.Errorf("validate: %w", err)
+		}
+
+		snapOpts.StorageVersion = data.Ver
+
+		if policy != nil {
+			if brigade, err = redactBrigade(brigade, policy, opts, psk, &snapOpts); err != nil {
+				return fmt.Errorf("redact: %w", err)
+			}
+		}
+
+		var (
+			payload = brigade
+			refKey  []byte
+		)
+
+		if opts.Incremental {
+			if refKey, err = referenceKey(opts); err != nil {
+				return fmt.Errorf("reference key: %w", err)
+			}
+
+			if payload, err = incrementalPayload(brigade, opts, refKey, &snapOpts); err != nil {
+				return fmt.Errorf("incremental: %w", err)
+			}
+		}
+
+		var r io.Reader = bytes.NewReader(payload)
+
+		if opts.Archive {
+			r = packBrigadeDir(opts.DbDir, archiveFiles, r, resizedFileInfo{FileInfo: brigadeInfo, size: int64(len(payload))})
+		}
+
+		if id, err = snapSnap.MakeSnapshotIDTo(w, r, snapOpts); err != nil {
 			return fmt.Errorf("snapshot: %w", err)
 		}
 
+		if opts.Incremental {
+			if err := updateReference(brigade, opts, refKey); err != nil {
+				return fmt.Errorf("incremental: %w", err)
+			}
+		}
+
 		return nil
 	}(); err != nil {
-		return nil, fmt.Errorf("make snapshot: %w", err)
+		return "", fmt.Errorf("make snapshot: %w", err)
 	}
 
 	wg.Wait()
 
 	if errIntegrity != nil {
-		return nil, fmt.Errorf("decode: %w", errIntegrity)
+		return "", fmt.Errorf("decode: %w", errIntegrity)
+	}
+
+	if streamed {
+		if err := validateBrigade(data, opts.Validate); err != nil {
+			return "", fmt.Errorf("validate: %w", err)
+		}
+	}
+
+	return id, nil
+}
+
+// scanStorageVersion returns the storage version of the brigade
+// scanning the top level fields without decoding the whole brigade.
+// Keydesk sets the version only when it creates the brigade,
+// so it is the version of the brigade file, not of the keydesk.
+func scanStorageVersion(r io.Reader) (int, error) {
+	dec := json.NewDecoder(r)
+
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return 0, fmt.Errorf("%w: object expected", ErrIntegrity)
+	}
+
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return 0, fmt.Errorf("%w: %w", ErrIntegrity, err)
+		}
+
+		if t == StorageVersionField {
+			ver := 0
+			if err := dec.Decode(&ver); err != nil {
+				return 0, fmt.Errorf("%w: %w", ErrIntegrity, err)
+			}
+
+			if ver <= 0 {
+				return 0, fmt.Errorf("%w: %d", ErrStorageVersion, ver)
+			}
+
+			return ver, nil
+		}
+
+		// skip the value
+		for depth := 0; ; {
+			t, err := dec.Token()
+			if err != nil {
+				return 0, fmt.Errorf("%w: %w", ErrIntegrity, err)
+			}
+
+			switch t {
+			case json.Delim('{'), json.Delim('['):
+				depth++
+			case json.Delim('}'), json.Delim(']'):
+				depth--
+			}
+
+			if depth == 0 {
+				break
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("%w: no %s", ErrStorageVersion, StorageVersionField)
+}
+
+// readKeys returns the realm and authorities keys from the key material
+// of the supervisor or from the keys files.
+func readKeys(opts *CommandOpts) (*rsa.PublicKey, []*snapCrypto.RSAPublicKey, error) {
+	if opts.KeysFD > 0 {
+		f, err := snapHelper.OpenFD(opts.KeysFD, "keys-fd")
+		if err != nil {
+			if errors.Is(err, syscall.EBADF) {
+				return nil, nil, fmt.Errorf("%w: %w", ErrKeysFD, err)
+			}
+
+			return nil, nil, err
+		}
+
+		defer f.Close()
+
+		km := &snapCrypto.KeyMaterial{}
+		if err := json.NewDecoder(io.LimitReader(f, 2*snapCore.MaxKeysFileSize*1024)).Decode(km); err != nil {
+			return nil, nil, fmt.Errorf("decode key material: %w", err)
+		}
+
+		realmKey, authKeys, err := km.Keys(opts.RealmFP)
+		if err != nil {
+			return nil, nil, fmt.Errorf("key material: %w", err)
+		}
+
+		return realmKey, authKeys, nil
+	}
+
+	realmKey, err := snapCrypto.FindPubKeyInFile(filepath.Join(opts.EtcDir, snapCrypto.DefaultRealmsKeysFileName), opts.RealmFP)
+	if err != nil {
+		return nil, nil, fmt.Errorf("find realm key: %w", err)
+	}
+
+	authKeys, err := snapCrypto.ReadAuthoritiesPubKeyFile(opts.EtcDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read authorities keys: %w", err)
+	}
+
+	return realmKey, authKeys, nil
+}
+
+// validateBrigade reports the brigade problems according to the strictness.
+func validateBrigade(data *storage.Brigade, strictness string) error {
+	if strictness == snapValidate.StrictnessOff {
+		return nil
 	}
 
-	return encriptedSnap, nil
+	problems := snapValidate.Brigade(data)
+	for _, p := range problems {
+		log.Printf("Validate: %s\n", p)
+	}
+
+	if len(problems) > 0 && strictness == snapValidate.StrictnessStrict {
+		return fmt.Errorf("%w: %d problems", snapValidate.ErrInvalid, len(problems))
+	}
+
+	return nil
 }
 
 func parseArgs() (*CommandOpts, error) {
@@ -208,9 +601,37 @@ func parseArgs() (*CommandOpts, error) {
 	brigadeID := flag.String("id", "", "BrigadeID (for test)")
 	filedbDir := flag.String("d", "", "Dir for db files (for test). Default: "+storage.DefaultHomeDir+"/<BrigadeID>")
 	etcDir := flag.String("c", "", "Dir for config files (for test). Default: "+DefaultSnapEtcDir)
+	pskFD := flag.Int("psk-fd", 0, "Read PSK from the file descriptor. Default: stdin")
+	pskFile := flag.String("psk-file", "", "Read PSK from the file accessible by the owner only. Default: stdin")
+	pskEnv := flag.String("psk-env", "", "Read PSK from the environment variable. Default: stdin")
+	checkPSK := flag.Bool("psk-check", false, "Only read and validate PSK")
+	keysFD := flag.Int("keys-fd", 0, "Read the key material of the supervisor from the file descriptor. Default: the keys files of the config dir")
+	referenceKeyFD := flag.Int("reference-key-fd", 0, "Read the brigade reference key of the supervisor from the file descriptor. Default: derive it from the "+snapDelta.ReferenceKeyFileName+" of the config dir")
+	archive := flag.Bool("archive", false, "Archive the allowlisted brigade dir files, not only "+storage.BrigadeFilename)
+	compression := flag.String("compress", snapCore.CompressionGzip, "Payload compression: "+snapCore.CompressionNone+", "+snapCore.CompressionGzip+" or "+snapCore.CompressionZstd)
+	compressionLevel := flag.Int("clevel", 0, "Compression level, algorithm specific. Default: 0 (algorithm default)")
+	incremental := flag.Bool("incremental", false, "Make the delta against the last snapshot reference if any, update the reference")
+	rebase := flag.Bool("rebase", false, "Make the full snapshot in the incremental mode and reset the reference")
+	baseTag := flag.String("base", "", "Tag of the last snapshot delivered to the realm, confirms its reference as the delta base")
+	validate := flag.String("validate", snapValidate.StrictnessWarn, "Brigade validation: "+snapValidate.StrictnessOff+", "+snapValidate.StrictnessWarn+" (report only) or "+snapValidate.StrictnessStrict+" (refuse to snapshot)")
+	encoding := flag.String("encoding", snapCore.EncodingJSON, "Envelope encoding: "+snapCore.EncodingJSON+" or "+snapCore.EncodingBinary+" (compact, for archival storage)")
+	detach := flag.String("detach", "", "Write the encrypted payload to the content addressed file in the dir, not to the envelope. Default: inline payload")
+	meta := flag.Bool("meta", false, "Add the public metadata to the envelope, authenticated for the realm by the header HMAC: users count, plaintext size, keydesk version and maintenance. Not allowed with -pad")
+	lockTimeout := flag.Duration("lock-timeout", DefaultLockTimeout, "Maximum time to wait for the brigade file lock, 0 means no limit")
+	padding := flag.String("pad", "", "Payload size padding: "+snapSnap.PaddingPowerOfTwo+" or comma separated bucket sizes, e.g. 64k,1m. Default: no padding")
 
 	flag.Parse()
 
+	pskSource := snapPSK.Source{
+		FD:   *pskFD,
+		File: *pskFile,
+		Env:  *pskEnv,
+	}
+
+	if *checkPSK {
+		return &CommandOpts{PSKSource: pskSource, CheckPSK: true}, nil
+	}
+
 	if *tag == "" {
 		return nil, ErrEmptyTag
 	}
@@ -219,6 +640,49 @@ func parseArgs() (*CommandOpts, error) {
 		return nil, ErrEmptyRealmFP
 	}
 
+	switch *compression {
+	case snapCore.CompressionNone, snapCore.CompressionGzip, snapCore.CompressionZstd:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCompression, *compression)
+	}
+
+	if (*incremental || *rebase) && *archive {
+		return nil, ErrIncrementalArchive
+	}
+
+	if *baseTag != "" {
+		if _, err := snapCore.ParseTag(*baseTag); err != nil {
+			return nil, fmt.Errorf("base: %w", err)
+		}
+	}
+
+	switch *encoding {
+	case snapCore.EncodingJSON, snapCore.EncodingBinary:
+	default:
+		return nil, fmt.Errorf("%w: %s", snapSnap.ErrUnknownEncoding, *encoding)
+	}
+
+	if err := snapValidate.CheckStrictness(*validate); err != nil {
+		return nil, err
+	}
+
+	pad, err := snapSnap.ParsePadding(*padding)
+	if err != nil {
+		return nil, fmt.Errorf("padding: %w", err)
+	}
+
+	if *meta && !pad.IsZero() {
+		return nil, snapSnap.ErrMetaPadding
+	}
+
+	detachedDir := ""
+	if *detach != "" {
+		detachedDir, err = filepath.Abs(*detach)
+		if err != nil {
+			return nil, fmt.Errorf("detach dir: %w", err)
+		}
+	}
+
 	if !strings.HasPrefix(*realmFP, "SHA256:") {
 		return nil, ErrInvalidRealmFP
 	}
@@ -237,6 +701,15 @@ func parseArgs() (*CommandOpts, error) {
 		return nil, fmt.Errorf("parse snap time: %w", err)
 	}
 
+	parsedTag, err := snapCore.ParseTag(*tag)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := parsedTag.CheckTime(time.Unix(gst, 0)); err != nil {
+		return nil, err
+	}
+
 	if *filedbDir != "" {
 		dbdir, err = filepath.Abs(*filedbDir)
 		if err != nil {
@@ -287,5 +760,21 @@ func parseArgs() (*CommandOpts, error) {
 		Tag:          *tag,
 		GlobalSnapAt: time.Unix(gst, 0).UTC(),
 		Maintenance:  *maintenance,
+		PSKSource:    pskSource,
+		Archive:      *archive,
+
+		Compression:      *compression,
+		CompressionLevel: *compressionLevel,
+		Padding:          pad,
+		Incremental:      *incremental || *rebase,
+		Rebase:           *rebase,
+		BaseTag:          *baseTag,
+		Validate:         *validate,
+		Encoding:         *encoding,
+		DetachedDir:      detachedDir,
+		Meta:             *meta,
+		LockTimeout:      max(*lockTimeout, 0),
+		KeysFD:           *keysFD,
+		ReferenceKeyFD:   *referenceKeyFD,
 	}, nil
 }
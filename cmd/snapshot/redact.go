@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	snapCrypto "github.com/vpngen/keydesk-snap/core/crypto"
+	snapHelper "github.com/vpngen/keydesk-snap/core/helper"
+	snapRedact "github.com/vpngen/keydesk-snap/core/redact"
+	snapSnap "github.com/vpngen/keydesk-snap/core/snap"
+)
+
+// RedactionPolicyFileName is a name of the redaction policy file in the config dir.
+const RedactionPolicyFileName = "redaction_policy.json"
+
+// readRedactionPolicy reads the redaction policy from the config dir.
+// It returns nil if there is no policy file.
+func readRedactionPolicy(etcDir string) (*snapRedact.Policy, error) {
+	data, err := snapHelper.ReadFileSafeSize(filepath.Join(etcDir, RedactionPolicyFileName), snapCore.MaxKeysFileSize)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("read policy: %w", err)
+	}
+
+	policy, err := snapRedact.ParsePolicy(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse policy: %w", err)
+	}
+
+	return policy, nil
+}
+
+// redactBrigade applies the policy to the brigade
+// and records the policy digest in the snapshot options.
+func redactBrigade(data []byte, policy *snapRedact.Policy, opts *CommandOpts, psk []byte, sopts *snapSnap.SnapOpts) ([]byte, error) {
+	key, err := snapCrypto.DeriveRedactionKey(psk, opts.BrigadeID)
+	if err != nil {
+		return nil, fmt.Errorf("redaction key: %w", err)
+	}
+
+	redacted, err := policy.Apply(data, key)
+	if err != nil {
+		return nil, fmt.Errorf("apply: %w", err)
+	}
+
+	sopts.RedactionPolicy, err = policy.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("policy digest: %w", err)
+	}
+
+	return redacted, nil
+}
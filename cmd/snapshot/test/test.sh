@@ -46,15 +46,27 @@ if [ ! -s "${CONF_DIR}/realms_keys" ]; then
         exit 1
 fi
 
-REALM_FP="SHA256:$(grep "ssh-rsa" "${CONF_DIR}/realms_keys" | head -n 1 | awk '{print $2}' | base64 -d | openssl dgst -sha256 -binary | base64 -w 0 | sed 's/=//g' | awk '{print $1}' )"
+if [ -x "../../snaptool/snaptool" ]; then
+        SNAPTOOL=../../snaptool/snaptool
+else
+        SNAPTOOL="go run ../../snaptool"
+fi
+
+# the problems of the other keys do not matter here
+REALM_FP="$(${SNAPTOOL} keys -c "${CONF_DIR}" -json 2>/dev/null | jq -r '[.[] | select(.file == "realms_keys") | .keys[] | select(.usable)][0].fingerprint // empty')"
+
+if [ -z "${REALM_FP}" ]; then
+        echo "No usable realm key found in ${CONF_DIR}"
+        exit 1
+fi
 
 if [ ! -s "${CONF_DIR}/authorities_keys" ]; then
         echo "No authorities keys found in ${CONF_DIR}"
         exit 1
 fi
 
-TAG="snaphost-test-$(date +%Y-%m-%dT%H:%M:%S)"
 SNAP_AT="$(date +%s)"
+TAG="$(date -u -d "@${SNAP_AT}" +%Y-%m-%dT%H:%M:%SZ)-manual-once-snapshot-test"
 
 PSK="$(dd if=/dev/urandom bs=16 count=1 2>/dev/null | base64 -w 0)"
 
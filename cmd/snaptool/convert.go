@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	snapHelper "github.com/vpngen/keydesk-snap/core/helper"
+	snapSnap "github.com/vpngen/keydesk-snap/core/snap"
+)
+
+var ErrAttachDetach = fmt.Errorf("both attach and detach")
+
+// convertCmd converts the envelope between the encodings
+// and between the inline and the detached payload.
+func convertCmd(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	in := fs.String("i", "", "Snapshot file. Default: stdin")
+	out := fs.String("o", "", "Output file. Default: stdout")
+	to := fs.String("to", "", "Target encoding: "+snapCore.EncodingJSON+" or "+snapCore.EncodingBinary+". Default: the other one, the same one with -attach or -detach")
+	detach := fs.String("detach", "", "Move the payload to the content addressed file in the dir")
+	attach := fs.String("attach", "", "Move the payload from the content addressed file in the dir to the envelope")
+
+	fs.Parse(args)
+
+	if *attach != "" && *detach != "" {
+		return ErrAttachDetach
+	}
+
+	data, err := readSnapshotFile(*in)
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+
+	e, err := decodeSnapshot(data)
+	if err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+
+	switch {
+	case *attach != "":
+		if err := snapSnap.AttachPayload(e, *attach); err != nil {
+			return fmt.Errorf("attach: %w", err)
+		}
+	case *detach != "":
+		if err := snapSnap.DetachPayload(e, *detach); err != nil {
+			return fmt.Errorf("detach: %w", err)
+		}
+	}
+
+	encoding := *to
+	if encoding == "" {
+		binary := snapSnap.IsBinaryEnvelope(data)
+		if *attach == "" && *detach == "" {
+			binary = !binary
+		}
+
+		encoding = snapCore.EncodingJSON
+		if binary {
+			encoding = snapCore.EncodingBinary
+		}
+	}
+
+	switch encoding {
+	case snapCore.EncodingJSON, snapCore.EncodingBinary:
+	default:
+		return fmt.Errorf("%w: %s", snapSnap.ErrUnknownEncoding, encoding)
+	}
+
+	return writeFileAtomic(*out, func(w io.Writer) error {
+		return snapSnap.EncodeEnvelope(w, e, encoding)
+	})
+}
+
+// readSnapshotFile reads the snapshot file or the stdin.
+func readSnapshotFile(filename string) ([]byte, error) {
+	if filename == "" {
+		data, err := io.ReadAll(io.LimitReader(os.Stdin, MaxSnapshotFileSize*1024+1))
+		if err != nil {
+			return nil, fmt.Errorf("read stdin: %w", err)
+		}
+
+		if len(data) > MaxSnapshotFileSize*1024 {
+			return nil, snapHelper.ErrFileTooBig
+		}
+
+		return data, nil
+	}
+
+	return snapHelper.ReadFileSafeSize(filename, MaxSnapshotFileSize)
+}
+
+// decodeSnapshot decodes the envelope in any encoding.
+func decodeSnapshot(data []byte) (*snapCore.EncryptedBrigade, error) {
+	if snapSnap.IsBinaryEnvelope(data) {
+		return snapSnap.DecodeBinaryEnvelope(bytes.NewReader(data))
+	}
+
+	return snapSnap.DecodeEnvelope(data)
+}
+
+// writeFileAtomic writes to the stdout or to the temporary file
+// renamed to the file on success.
+func writeFileAtomic(filename string, write func(w io.Writer) error) error {
+	if filename == "" {
+		w := bufio.NewWriter(os.Stdout)
+
+		if err := write(w); err != nil {
+			return err
+		}
+
+		return w.Flush()
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(filename), ".snaptool-")
+	if err != nil {
+		return fmt.Errorf("create temp: %w", err)
+	}
+
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+
+	if err := write(w); err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), filename); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+
+	return nil
+}
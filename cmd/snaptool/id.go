@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	snapSnap "github.com/vpngen/keydesk-snap/core/snap"
+)
+
+// idCmd prints the snapshot ID of the envelope in any encoding.
+func idCmd(args []string) error {
+	fs := flag.NewFlagSet("id", flag.ExitOnError)
+	in := fs.String("i", "", "Snapshot file. Default: stdin")
+	canonical := fs.Bool("canonical", false, "Print the canonical envelope the ID is the hash of")
+
+	fs.Parse(args)
+
+	data, err := readSnapshotFile(*in)
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+
+	e, err := decodeSnapshot(data)
+	if err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+
+	if *canonical {
+		data, err := snapSnap.CanonicalEnvelope(e)
+		if err != nil {
+			return fmt.Errorf("canonical: %w", err)
+		}
+
+		fmt.Println(string(data))
+
+		return nil
+	}
+
+	id, err := snapSnap.SnapshotID(e)
+	if err != nil {
+		return fmt.Errorf("id: %w", err)
+	}
+
+	fmt.Println(id)
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	snapCrypto "github.com/vpngen/keydesk-snap/core/crypto"
+	snapHelper "github.com/vpngen/keydesk-snap/core/helper"
+	snapSnap "github.com/vpngen/keydesk-snap/core/snap"
+)
+
+var ErrProblems = fmt.Errorf("envelope problems found")
+
+// inspection is the JSON output of the inspect command.
+type inspection struct {
+	*snapSnap.Inspection
+	Problems []string `json:"problems"`
+}
+
+// inspectCmd prints the envelope summary and problems without any secret keys.
+func inspectCmd(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	in := fs.String("i", "", "Snapshot file. Default: stdin")
+	confDir := fs.String("c", "", "Dir of the "+snapCrypto.DefaultRealmsKeysFileName+" and "+snapCrypto.DefaultAuthoritiesKeysFileName+" files to check the fingerprints against. Default: no check")
+	asJSON := fs.Bool("json", false, "JSON output")
+
+	fs.Parse(args)
+
+	data, err := readSnapshotFile(*in)
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+
+	e, err := decodeSnapshot(data)
+	if err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+
+	known := snapSnap.KnownKeys{}
+
+	if *confDir != "" {
+		if known, err = readKnownKeys(*confDir); err != nil {
+			return fmt.Errorf("known keys: %w", err)
+		}
+	}
+
+	res, err := snapSnap.Inspect(e, known, time.Now())
+	if err != nil {
+		return fmt.Errorf("inspect: %w", err)
+	}
+
+	out := &inspection{Inspection: res, Problems: []string{}}
+	for _, p := range res.Problems {
+		out.Problems = append(out.Problems, p.Error())
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		if err := enc.Encode(out); err != nil {
+			return fmt.Errorf("encode: %w", err)
+		}
+	} else {
+		printInspection(out)
+	}
+
+	if len(out.Problems) > 0 {
+		return fmt.Errorf("%w: %d", ErrProblems, len(out.Problems))
+	}
+
+	return nil
+}
+
+// readKnownKeys returns the fingerprints of the RSA keys of the config dir.
+func readKnownKeys(dir string) (snapSnap.KnownKeys, error) {
+	data, err := snapHelper.ReadFileSafeSize(filepath.Join(dir, snapCrypto.DefaultRealmsKeysFileName), snapCore.MaxKeysFileSize)
+	if err != nil {
+		return snapSnap.KnownKeys{}, fmt.Errorf("read realms keys: %w", err)
+	}
+
+	realms, err := snapCrypto.GetRSAPublicKeysList(data)
+	if err != nil {
+		return snapSnap.KnownKeys{}, fmt.Errorf("realms keys: %w", err)
+	}
+
+	auths, err := snapCrypto.ReadAuthoritiesPubKeyFile(dir)
+	if err != nil {
+		return snapSnap.KnownKeys{}, fmt.Errorf("authorities keys: %w", err)
+	}
+
+	known := snapSnap.KnownKeys{Realms: []string{}, Authorities: []string{}}
+
+	for _, key := range realms {
+		known.Realms = append(known.Realms, key.FingerPrint)
+	}
+
+	for _, key := range auths {
+		known.Authorities = append(known.Authorities, key.FingerPrint)
+	}
+
+	return known, nil
+}
+
+func printInspection(in *inspection) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	payload := fmt.Sprintf("%d bytes", in.PayloadSize)
+	if in.Detached {
+		payload += ", detached"
+	}
+
+	format := in.PayloadFormat
+	if format == "" {
+		format = "brigade.json"
+	}
+
+	fmt.Fprintf(w, "ID:\t%s\n", in.ID)
+	fmt.Fprintf(w, "Version:\t%d\n", in.Version)
+	fmt.Fprintf(w, "Tag:\t%s\n", in.Tag)
+	fmt.Fprintf(w, "Brigade ID:\t%s\n", in.BrigadeID)
+	fmt.Fprintf(w, "Global snap at:\t%s\n", in.GlobalSnapAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "Local snap at:\t%s\n", in.LocalSnapAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "Realm key:\t%s\n", in.RealmKeyFP)
+	fmt.Fprintf(w, "Authority keys:\t%s\n", strings.Join(in.AuthorityKeyFPs, ", "))
+	fmt.Fprintf(w, "Payload:\t%s\n", payload)
+	fmt.Fprintf(w, "Payload format:\t%s\n", format)
+	fmt.Fprintf(w, "Compression:\t%s\n", in.Compression)
+	fmt.Fprintf(w, "PSK derivation:\t%s\n", orNone(in.PSKDerivation))
+	fmt.Fprintf(w, "Plaintext digest:\t%s\n", orNone(in.PlaintextDigest))
+	fmt.Fprintf(w, "Digest HMAC:\t%t\n", in.DigestHMAC)
+	fmt.Fprintf(w, "Header HMAC:\t%t\n", in.HeaderHMAC)
+
+	if in.BaseTag != "" {
+		fmt.Fprintf(w, "Base tag:\t%s\n", in.BaseTag)
+	}
+
+	if in.RedactionPolicy != "" {
+		fmt.Fprintf(w, "Redaction policy:\t%s\n", in.RedactionPolicy)
+	}
+
+	if in.StorageVersion != 0 {
+		fmt.Fprintf(w, "Storage version:\t%d\n", in.StorageVersion)
+	}
+
+	if in.KeydeskVersion != "" {
+		fmt.Fprintf(w, "Keydesk version:\t%s\n", in.KeydeskVersion)
+	}
+
+	if in.Meta != nil {
+		fmt.Fprintf(w, "Users:\t%d\n", in.Meta.Users)
+		fmt.Fprintf(w, "Plaintext size:\t%d bytes\n", in.Meta.PlaintextSize)
+
+		if in.Meta.MaintenanceTill != 0 {
+			fmt.Fprintf(w, "Maintenance till:\t%s\n", time.Unix(in.Meta.MaintenanceTill, 0).UTC().Format(time.RFC3339))
+		}
+	}
+
+	w.Flush()
+
+	if len(in.Problems) == 0 {
+		fmt.Println("Problems: none")
+
+		return
+	}
+
+	fmt.Println("Problems:")
+
+	for _, p := range in.Problems {
+		fmt.Printf("  - %s\n", p)
+	}
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+
+	return s
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	snapCrypto "github.com/vpngen/keydesk-snap/core/crypto"
+	snapHelper "github.com/vpngen/keydesk-snap/core/helper"
+)
+
+// DefaultSnapEtcDir is a config dir of the snapshot tool.
+const DefaultSnapEtcDir = "/etc/vg-keydesk-snap"
+
+var ErrKeyProblems = fmt.Errorf("key problems found")
+
+// keysFile is the inventory of the keys file.
+type keysFile struct {
+	File  string                `json:"file"`
+	Keys  []*snapCrypto.KeyInfo `json:"keys"`
+	Error string                `json:"error,omitempty"`
+}
+
+// keysCmd lists the keys of the realms and authorities keys files.
+func keysCmd(args []string) error {
+	fs := flag.NewFlagSet("keys", flag.ExitOnError)
+	confDir := fs.String("c", DefaultSnapEtcDir, "Dir of the "+snapCrypto.DefaultRealmsKeysFileName+" and "+snapCrypto.DefaultAuthoritiesKeysFileName+" files")
+	asJSON := fs.Bool("json", false, "JSON output")
+
+	fs.Parse(args)
+
+	files := []*keysFile{}
+	problems := 0
+
+	for _, name := range []string{snapCrypto.DefaultRealmsKeysFileName, snapCrypto.DefaultAuthoritiesKeysFileName} {
+		f := keysInventory(filepath.Join(*confDir, name))
+		f.File = name
+
+		if f.Error != "" {
+			problems++
+		}
+
+		for _, info := range f.Keys {
+			if info.Error != "" {
+				problems++
+			}
+		}
+
+		files = append(files, f)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		if err := enc.Encode(files); err != nil {
+			return fmt.Errorf("encode: %w", err)
+		}
+	} else {
+		printKeys(files)
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("%w: %d", ErrKeyProblems, problems)
+	}
+
+	return nil
+}
+
+// keysInventory returns the inventory of the keys file. Every usable
+// key is looked up by the fingerprint as the snapshot does.
+func keysInventory(path string) *keysFile {
+	f := &keysFile{Keys: []*snapCrypto.KeyInfo{}}
+
+	data, err := snapHelper.ReadFileSafeSize(path, snapCore.MaxKeysFileSize)
+	if err != nil {
+		f.Error = fmt.Sprintf("read: %s", err)
+
+		return f
+	}
+
+	// the entries of the unusable file are kept to find the broken line
+	keys, err := snapCrypto.KeysInventory(data)
+	if err != nil {
+		f.Error = err.Error()
+	}
+
+	if keys == nil {
+		return f
+	}
+
+	f.Keys = keys
+
+	for _, info := range f.Keys {
+		if !info.Usable || info.Error != "" {
+			continue
+		}
+
+		if _, err := snapCrypto.FindPubKeyInFile(path, info.FingerPrint); err != nil {
+			info.Error = fmt.Sprintf("find key: %s", err)
+		}
+	}
+
+	return f
+}
+
+func printKeys(files []*keysFile) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "FILE\tLINE\tTYPE\tBITS\tFINGERPRINT\tUSABLE\tCOMMENT\tERROR")
+
+	for _, f := range files {
+		if f.Error != "" {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t-\t-\t%s\n", f.File, f.Error)
+		}
+
+		for _, info := range f.Keys {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%s\t%t\t%s\t%s\n",
+				f.File, info.Line, orNone(info.Type), info.Bits, orNone(info.FingerPrint),
+				info.Usable, info.Comment, info.Error)
+		}
+	}
+
+	w.Flush()
+}
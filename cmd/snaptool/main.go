@@ -0,0 +1,58 @@
+// snaptool is a set of the realm side tools for the brigade snapshots.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// MaxSnapshotFileSize is a maximum snapshot file size in KB.
+const MaxSnapshotFileSize = 1024 * 1024 // 1 GB
+
+var ErrUnknownCommand = fmt.Errorf("unknown command")
+
+type command struct {
+	name string
+	desc string
+	run  func(args []string) error
+}
+
+var commands = []command{
+	{name: "convert", desc: "Convert the snapshot envelope between JSON and binary encodings, inline and detached payload", run: convertCmd},
+	{name: "id", desc: "Print the snapshot ID or the canonical envelope", run: idCmd},
+	{name: "inspect", desc: "Print the envelope summary and problems, no keys are needed", run: inspectCmd},
+	{name: "keys", desc: "List the realms and authorities keys with their fingerprints and problems", run: keysCmd},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name, args := os.Args[1], os.Args[2:]
+
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+
+		if err := cmd.run(args); err != nil {
+			log.Fatalf("%s: %s\n", name, err)
+		}
+
+		return
+	}
+
+	usage()
+	log.Fatalf("%s: %s\n", ErrUnknownCommand, name)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.desc)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	snapSnap "github.com/vpngen/keydesk-snap/core/snap"
+	snapValidate "github.com/vpngen/keydesk-snap/core/validate"
+)
+
+// MaxCommandSize is a maximum size of the SSH command line.
+const MaxCommandSize = 64 * 1024
+
+var (
+	ErrEmptyCommand   = fmt.Errorf("empty command")
+	ErrCommandTooLong = fmt.Errorf("command too long")
+	ErrUnknownCommand = fmt.Errorf("unknown command")
+	ErrInvalidToken   = fmt.Errorf("invalid characters")
+	ErrUnknownFlag    = fmt.Errorf("unknown flag")
+	ErrDuplicateFlag  = fmt.Errorf("duplicate flag")
+	ErrMissingValue   = fmt.Errorf("missing flag value")
+	ErrUnexpectedArg  = fmt.Errorf("unexpected argument")
+	ErrInvalidValue   = fmt.Errorf("invalid flag value")
+)
+
+// tokenRe is a whole command token. There is no quoting and no shell
+// special characters, the command is never passed to a shell.
+var tokenRe = regexp.MustCompile(`^[A-Za-z0-9_.:+/=,-]+$`)
+
+// value checks the flag value, nil means the boolean flag.
+type value func(s string) error
+
+// spec is an allowed command: the executable next to the dispatcher
+// and the grammar of its flags.
+type spec struct {
+	bin   string
+	flags map[string]value
+}
+
+// commands is the allowlist of the SSH commands.
+var commands = map[string]spec{
+	"fetchsnaps": {
+		bin: "fetchsnaps",
+		flags: map[string]value{
+			"tag":          tagValue,
+			"stime":        uintValue,
+			"rfp":          fingerprintValue,
+			"mnt":          uintValue,
+			"list":         brigadesValue,
+			"all":          nil,
+			"exclude":      brigadesValue,
+			"archive":      nil,
+			"meta":         nil,
+			"incremental":  nil,
+			"rebase":       nil,
+			"base":         tagValue,
+			"validate":     snapValidate.CheckStrictness,
+			"compress":     enumValue(snapCore.CompressionNone, snapCore.CompressionGzip, snapCore.CompressionZstd),
+			"pad":          paddingValue,
+			"lock-timeout": durationValue,
+			"workers":      uintValue,
+			"ndjson":       nil,
+			"chunked":      nil,
+		},
+	},
+}
+
+// parseCommand splits the command line and checks it against
+// the allowlist. Only the single dash flags are accepted,
+// as -name, -name value or -name=value.
+func parseCommand(line string) (*spec, []string, error) {
+	if len(line) > MaxCommandSize {
+		return nil, nil, ErrCommandTooLong
+	}
+
+	tokens := strings.Fields(line)
+	if len(tokens) == 0 {
+		return nil, nil, ErrEmptyCommand
+	}
+
+	for _, token := range tokens {
+		if !tokenRe.MatchString(token) {
+			return nil, nil, fmt.Errorf("%w: %q", ErrInvalidToken, token)
+		}
+	}
+
+	cmd, ok := commands[tokens[0]]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownCommand, tokens[0])
+	}
+
+	args := []string{}
+	seen := map[string]bool{}
+
+	for i := 1; i < len(tokens); i++ {
+		token := tokens[i]
+
+		if !strings.HasPrefix(token, "-") || strings.HasPrefix(token, "--") {
+			return nil, nil, fmt.Errorf("%w: %s", ErrUnexpectedArg, token)
+		}
+
+		name, val, hasVal := strings.Cut(token[1:], "=")
+
+		check, ok := cmd.flags[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: -%s", ErrUnknownFlag, name)
+		}
+
+		if seen[name] {
+			return nil, nil, fmt.Errorf("%w: -%s", ErrDuplicateFlag, name)
+		}
+
+		seen[name] = true
+
+		if check == nil {
+			if hasVal {
+				return nil, nil, fmt.Errorf("%w: -%s is boolean", ErrInvalidValue, name)
+			}
+
+			args = append(args, "-"+name)
+
+			continue
+		}
+
+		if !hasVal {
+			if i+1 == len(tokens) {
+				return nil, nil, fmt.Errorf("%w: -%s", ErrMissingValue, name)
+			}
+
+			i++
+			val = tokens[i]
+		}
+
+		if err := check(val); err != nil {
+			return nil, nil, fmt.Errorf("%w: -%s: %w", ErrInvalidValue, name, err)
+		}
+
+		args = append(args, "-"+name, val)
+	}
+
+	return &cmd, args, nil
+}
+
+func tagValue(s string) error {
+	_, err := snapCore.ParseTag(s)
+
+	return err
+}
+
+func uintValue(s string) error {
+	_, err := strconv.ParseUint(s, 10, 63)
+
+	return err
+}
+
+func durationValue(s string) error {
+	_, err := time.ParseDuration(s)
+
+	return err
+}
+
+func paddingValue(s string) error {
+	_, err := snapSnap.ParsePadding(s)
+
+	return err
+}
+
+func fingerprintValue(s string) error {
+	fp, ok := strings.CutPrefix(s, "SHA256:")
+	if !ok {
+		return fmt.Errorf("not a sha256 fingerprint")
+	}
+
+	buf, err := base64.StdEncoding.WithPadding(base64.NoPadding).DecodeString(fp)
+	if err != nil || len(buf) != 32 {
+		return fmt.Errorf("not a sha256 fingerprint")
+	}
+
+	return nil
+}
+
+// brigadesValue skips the empty entries as the fetch does.
+func brigadesValue(s string) error {
+	n := 0
+
+	for _, id := range strings.Split(s, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+
+		n++
+
+		buf, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(id)
+		if err != nil || len(buf) != 16 {
+			return fmt.Errorf("brigade id: %q", id)
+		}
+	}
+
+	if n == 0 {
+		return fmt.Errorf("no brigade id")
+	}
+
+	return nil
+}
+
+func enumValue(values ...string) value {
+	return func(s string) error {
+		for _, v := range values {
+			if s == v {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("one of %s", strings.Join(values, ", "))
+	}
+}
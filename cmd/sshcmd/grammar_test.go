@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const (
+	testTag     = "2023-01-01T00:00:00Z-regular-quarter-snapshot"
+	testRealmFP = "SHA256:g3+OoyULfxUvOr/JTcpY0ZgIajOqPq+BU8Eff6wHMwk"
+	testBrigade = "AAAAAAAAAAAAAAAAAAAAAAAAAA"
+)
+
+func Test_parseCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want []string
+	}{
+		{
+			name: "separate values",
+			line: "fetchsnaps -tag " + testTag + " -stime 1672531200 -rfp " + testRealmFP + " -list " + testBrigade,
+			want: []string{"-tag", testTag, "-stime", "1672531200", "-rfp", testRealmFP, "-list", testBrigade},
+		},
+		{
+			name: "inline values",
+			line: "fetchsnaps -tag=" + testTag + " -base=" + testTag + " -incremental -all -exclude=" + testBrigade + ",AAAAAAAAAAAAAAAAAAAAAAAAAE",
+			want: []string{"-tag", testTag, "-base", testTag, "-incremental", "-all", "-exclude", testBrigade + ",AAAAAAAAAAAAAAAAAAAAAAAAAE"},
+		},
+		{
+			name: "each value type",
+			line: "  fetchsnaps\t-mnt 1700000000 -validate strict -compress zstd -pad 64k,1m -lock-timeout 1m30s -workers 4 -archive -meta -rebase -ndjson -chunked ",
+			want: []string{
+				"-mnt", "1700000000", "-validate", "strict", "-compress", "zstd", "-pad", "64k,1m", "-lock-timeout", "1m30s", "-workers", "4",
+				"-archive", "-meta", "-rebase", "-ndjson", "-chunked",
+			},
+		},
+		{
+			name: "empty list entries",
+			line: "fetchsnaps -list " + testBrigade + ",,AAAAAAAAAAAAAAAAAAAAAAAAAE, -all -exclude=" + testBrigade + ",",
+			want: []string{"-list", testBrigade + ",,AAAAAAAAAAAAAAAAAAAAAAAAAE,", "-all", "-exclude", testBrigade + ","},
+		},
+		{name: "no flags", line: "fetchsnaps", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, args, err := parseCommand(tt.line)
+			if err != nil {
+				t.Fatalf("parseCommand() error = %v", err)
+			}
+
+			if cmd.bin != "fetchsnaps" || !slices.Equal(args, tt.want) {
+				t.Errorf("parseCommand() = %s %q, want fetchsnaps %q", cmd.bin, args, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseCommand_Reject(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		err  error
+	}{
+		{name: "empty", line: " \t ", err: ErrEmptyCommand},
+		{name: "oversize", line: "fetchsnaps -list " + strings.Repeat(testBrigade+",", MaxCommandSize/len(testBrigade)), err: ErrCommandTooLong},
+		{name: "unknown command", line: "restore -tag " + testTag, err: ErrUnknownCommand},
+		{name: "path command", line: "/opt/vgkeydesk-snap/fetchsnaps -all", err: ErrUnknownCommand},
+		{name: "semicolon", line: "fetchsnaps -all; rm -rf /", err: ErrInvalidToken},
+		{name: "pipe", line: "fetchsnaps -all | sh", err: ErrInvalidToken},
+		{name: "ampersand", line: "fetchsnaps -all && id", err: ErrInvalidToken},
+		{name: "substitution", line: "fetchsnaps -tag $(id)", err: ErrInvalidToken},
+		{name: "backtick", line: "fetchsnaps -tag `id`", err: ErrInvalidToken},
+		{name: "redirect", line: "fetchsnaps -all >/tmp/x", err: ErrInvalidToken},
+		{name: "quote", line: "fetchsnaps -tag '" + testTag + "'", err: ErrInvalidToken},
+		{name: "glob", line: "fetchsnaps -list *", err: ErrInvalidToken},
+		{name: "double dash", line: "fetchsnaps --all", err: ErrUnexpectedArg},
+		{name: "positional", line: "fetchsnaps -all " + testBrigade, err: ErrUnexpectedArg},
+		{name: "unknown flag", line: "fetchsnaps -all -d /tmp", err: ErrUnknownFlag},
+		{name: "debug conf flag", line: "fetchsnaps -all -c=/tmp", err: ErrUnknownFlag},
+		{name: "empty flag", line: "fetchsnaps - -all", err: ErrUnknownFlag},
+		{name: "duplicate flag", line: "fetchsnaps -all -all", err: ErrDuplicateFlag},
+		{name: "duplicate value flag", line: "fetchsnaps -tag " + testTag + " -tag=" + testTag, err: ErrDuplicateFlag},
+		{name: "boolean with value", line: "fetchsnaps -all=true", err: ErrInvalidValue},
+		{name: "missing value", line: "fetchsnaps -all -tag", err: ErrMissingValue},
+		{name: "flag as value", line: "fetchsnaps -tag -all", err: ErrInvalidValue},
+		{name: "empty value", line: "fetchsnaps -tag=", err: ErrInvalidValue},
+		{name: "bad tag", line: "fetchsnaps -tag snapshot", err: ErrInvalidValue},
+		{name: "bad base", line: "fetchsnaps -base 2023-01-01-regular-quarter-snapshot", err: ErrInvalidValue},
+		{name: "bad fingerprint", line: "fetchsnaps -rfp g3+OoyULfxUvOr/JTcpY0ZgIajOqPq+BU8Eff6wHMwk", err: ErrInvalidValue},
+		{name: "short fingerprint", line: "fetchsnaps -rfp SHA256:g3+OoyULfxUvOr", err: ErrInvalidValue},
+		{name: "bad brigade", line: "fetchsnaps -list brigade1", err: ErrInvalidValue},
+		{name: "bad brigade in list", line: "fetchsnaps -list " + testBrigade + ",..", err: ErrInvalidValue},
+		{name: "bad exclude", line: "fetchsnaps -exclude " + testBrigade + ",brigade1", err: ErrInvalidValue},
+		{name: "only commas", line: "fetchsnaps -list ,,", err: ErrInvalidValue},
+		{name: "bad time", line: "fetchsnaps -stime -1", err: ErrInvalidValue},
+		{name: "bad workers", line: "fetchsnaps -workers 1e3", err: ErrInvalidValue},
+		{name: "bad duration", line: "fetchsnaps -lock-timeout 10", err: ErrInvalidValue},
+		{name: "bad compression", line: "fetchsnaps -compress lzma", err: ErrInvalidValue},
+		{name: "bad validation", line: "fetchsnaps -validate loose", err: ErrInvalidValue},
+		{name: "bad padding", line: "fetchsnaps -pad 1x", err: ErrInvalidValue},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, args, err := parseCommand(tt.line)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseCommand() error = %v, want %v", err, tt.err)
+			}
+
+			if cmd != nil || args != nil {
+				t.Errorf("parseCommand() = %v, %q, want nothing", cmd, args)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+// sshcmd is the SSH forced command of the snapshot tools. It checks
+// SSH_ORIGINAL_COMMAND against the allowlist and executes the command
+// with the same stdin, so the PSK is passed as is.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/syslog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	snapHelper "github.com/vpngen/keydesk-snap/core/helper"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// SafePath is a PATH of the executed commands.
+	SafePath = "/usr/sbin:/usr/bin:/sbin:/bin"
+	// SyslogTag is a tag of the audit log messages.
+	SyslogTag = "vgkeydesk-snap"
+	// MaxUserAuthSize is a maximum size of the SSH_USER_AUTH file in KB.
+	MaxUserAuthSize = 64
+
+	// Error response descriptions.
+	DescBadRequest    = "Bad request"
+	DescInternalError = "Internal error"
+)
+
+func main() {
+	// the command line args are for the keys forced
+	// with the command, not with the dispatcher only
+	line, ok := os.LookupEnv("SSH_ORIGINAL_COMMAND")
+	if !ok {
+		line = strings.Join(os.Args[1:], " ")
+	}
+
+	logger := auditLogger()
+	fp := authKeyFingerprint(os.Getenv("SSH_USER_AUTH"))
+
+	cmd, args, err := parseCommand(line)
+	if err != nil {
+		logger.Printf("Reject command of key %s: %s\n", fp, err)
+		fatal(http.StatusBadRequest, DescBadRequest, err.Error())
+	}
+
+	logger.Printf("Run command of key %s: %s %s\n", fp, cmd.bin, strings.Join(args, " "))
+
+	exe, err := os.Executable()
+	if err != nil {
+		fatal(http.StatusInternalServerError, DescInternalError, fmt.Sprintf("Executable: %s", err))
+	}
+
+	bin := filepath.Join(filepath.Dir(exe), cmd.bin)
+
+	// exec keeps the stdin and the stdout of the session untouched
+	err = syscall.Exec(bin, append([]string{bin}, args...), []string{"PATH=" + SafePath})
+
+	logger.Printf("Exec %s: %s\n", bin, err)
+	fatal(http.StatusInternalServerError, DescInternalError, fmt.Sprintf("Exec %s: %s", cmd.bin, err))
+}
+
+// fatal writes the error response and exits.
+func fatal(code int, desc, msg string) {
+	json.NewEncoder(os.Stdout).Encode(&snapCore.ErrorResponse{
+		Code:    code,
+		Desc:    desc,
+		Status:  "error",
+		Message: msg,
+	})
+
+	os.Exit(1)
+}
+
+// auditLogger returns the syslog logger or the stderr one
+// if the syslog is not available.
+func auditLogger() *log.Logger {
+	w, err := syslog.New(syslog.LOG_AUTH|syslog.LOG_INFO, SyslogTag)
+	if err != nil {
+		return log.Default()
+	}
+
+	return log.New(w, "", 0)
+}
+
+// authKeyFingerprint returns the fingerprints of the public keys
+// the session is authenticated with, the sshd ExposeAuthInfo file
+// has the "publickey <key>" lines for them.
+func authKeyFingerprint(path string) string {
+	if path == "" {
+		return "unknown"
+	}
+
+	data, err := snapHelper.ReadFileSafeSize(path, MaxUserAuthSize)
+	if err != nil {
+		return "unknown"
+	}
+
+	fps := []string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, ok := strings.CutPrefix(scanner.Text(), "publickey ")
+		if !ok {
+			continue
+		}
+
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+		if err != nil {
+			continue
+		}
+
+		fps = append(fps, ssh.FingerprintSHA256(pub))
+	}
+
+	if len(fps) == 0 {
+		return "unknown"
+	}
+
+	return strings.Join(fps, ",")
+}
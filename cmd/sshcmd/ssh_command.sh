@@ -1,25 +1,6 @@
 #!/bin/sh
 
-# interpret first argument as command
-# pass rest args to scripts
+# compatibility wrapper for the keys forced with the script,
+# the commands are dispatched by ssh_command
 
-printdef() {
-    echo "Usage: <command> <args...>" >&2
-    exit 1
-}
-
-if [ $# -eq 0 ]; then 
-    printdef
-fi
-
-cmd=${1}; shift
-basedir=$(dirname "$0")
-
-set -e
-
-if [ "${cmd}" = "fetchsnaps" ]; then
-        "${basedir}/fetchsnaps.sh" "$@"
-else
-    echo "Unknown command: ${cmd}"
-    printdef
-fi
+exec "$(dirname "$0")/ssh_command" "$@"
@@ -0,0 +1,461 @@
+package archive
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// ManifestName is a name of the manifest entry.
+// It is always the last entry of the archive.
+const ManifestName = ".snapshot-manifest.json"
+
+// MaxManifestSize is a maximum manifest size.
+const MaxManifestSize = 1024 * 1024 // 1 MB
+
+var (
+	ErrInvalidName      = errors.New("invalid file name")
+	ErrDuplicateName    = errors.New("duplicate file name")
+	ErrNoManifest       = errors.New("no manifest")
+	ErrNotInManifest    = errors.New("file is not in manifest")
+	ErrMissingFile      = errors.New("file is missing in archive")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrSizeMismatch     = errors.New("size mismatch")
+	ErrNotRegular       = errors.New("not a regular file")
+	ErrModeMismatch     = errors.New("mode mismatch")
+	ErrNotDir           = errors.New("not a directory")
+)
+
+// FileInfo is a file metadata record.
+type FileInfo struct {
+	Name   string      `json:"name"`
+	Mode   fs.FileMode `json:"mode"`
+	Size   int64       `json:"size"`
+	SHA256 string      `json:"sha256"`
+}
+
+// Manifest is a list of the archived files.
+type Manifest struct {
+	Files []FileInfo `json:"files"`
+}
+
+// Writer packs files into the tar stream.
+type Writer struct {
+	tw       *tar.Writer
+	manifest Manifest
+	names    map[string]struct{}
+}
+
+// NewWriter returns a new archive writer.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		tw:    tar.NewWriter(w),
+		names: make(map[string]struct{}),
+	}
+}
+
+// AddFile adds the file content from the reader.
+// Name must be a plain file name without directories.
+func (w *Writer) AddFile(name string, mode fs.FileMode, size int64, r io.Reader) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+
+	if _, ok := w.names[name]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateName, name)
+	}
+
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(mode.Perm()),
+		Size:     size,
+		ModTime:  time.Unix(0, 0),
+		Format:   tar.FormatPAX,
+	}
+
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("header: %w", err)
+	}
+
+	h := sha256.New()
+
+	n, err := io.Copy(w.tw, io.TeeReader(io.LimitReader(r, size), h))
+	if err != nil {
+		return fmt.Errorf("copy %s: %w", name, err)
+	}
+
+	if n != size {
+		return fmt.Errorf("%w: %s: %d, want %d", ErrSizeMismatch, name, n, size)
+	}
+
+	w.names[name] = struct{}{}
+	w.manifest.Files = append(w.manifest.Files, FileInfo{
+		Name:   name,
+		Mode:   mode.Perm(),
+		Size:   size,
+		SHA256: hexSum(h),
+	})
+
+	return nil
+}
+
+// AddFileFromDir adds the regular file from the directory.
+// The symlinks are not followed, they are refused as the other
+// non regular files. The fs.ErrNotExist error is returned
+// if the file doesn't exist.
+func (w *Writer) AddFileFromDir(dir, name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, name)
+
+	lfi, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("stat: %w", err)
+	}
+
+	if !lfi.Mode().IsRegular() {
+		return fmt.Errorf("%w: %s", ErrNotRegular, name)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat: %w", err)
+	}
+
+	// the file can be replaced between the stat and the open
+	if !fi.Mode().IsRegular() || !os.SameFile(fi, lfi) {
+		return fmt.Errorf("%w: %s", ErrNotRegular, name)
+	}
+
+	return w.AddFile(name, fi.Mode(), fi.Size(), f)
+}
+
+// Close writes the manifest and closes the tar stream.
+func (w *Writer) Close() error {
+	data, err := json.Marshal(w.manifest)
+	if err != nil {
+		return fmt.Errorf("marshal manifest: %w", err)
+	}
+
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     ManifestName,
+		Mode:     0o600,
+		Size:     int64(len(data)),
+		ModTime:  time.Unix(0, 0),
+		Format:   tar.FormatPAX,
+	}
+
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("manifest header: %w", err)
+	}
+
+	if _, err := w.tw.Write(data); err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+
+	if err := w.tw.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+
+	return nil
+}
+
+// Unpack extracts the archive into the dir.
+// Files are extracted into the sibling staging directory first,
+// the staging directory replaces the dir with one rename only if all
+// of them match the manifest. The other entries of the existing dir
+// are hard linked into the staging directory, so they are kept.
+// The existing files of the dir are not overwritten unless overwrite is set,
+// the fs.ErrExist error is returned instead.
+func Unpack(r io.Reader, dir string, overwrite bool) (*Manifest, error) {
+	dir = filepath.Clean(dir)
+
+	stage, err := os.MkdirTemp(filepath.Dir(dir), "."+filepath.Base(dir)+".unpack-")
+	if err != nil {
+		return nil, fmt.Errorf("staging dir: %w", err)
+	}
+
+	defer os.RemoveAll(stage)
+
+	var (
+		manifest *Manifest
+		sums     = make(map[string]FileInfo)
+		tr       = tar.NewReader(r)
+	)
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("next: %w", err)
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("%w: %s", ErrNotRegular, hdr.Name)
+		}
+
+		if hdr.Name == ManifestName {
+			manifest, err = readManifest(tr)
+			if err != nil {
+				return nil, fmt.Errorf("manifest: %w", err)
+			}
+
+			continue
+		}
+
+		if manifest != nil {
+			return nil, fmt.Errorf("%w: %s after manifest", ErrNotInManifest, hdr.Name)
+		}
+
+		if err := checkName(hdr.Name); err != nil {
+			return nil, err
+		}
+
+		if _, ok := sums[hdr.Name]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateName, hdr.Name)
+		}
+
+		fi, err := extractFile(stage, hdr, tr)
+		if err != nil {
+			return nil, fmt.Errorf("extract %s: %w", hdr.Name, err)
+		}
+
+		sums[hdr.Name] = fi
+	}
+
+	if manifest == nil {
+		return nil, ErrNoManifest
+	}
+
+	if err := verify(manifest, sums); err != nil {
+		return nil, err
+	}
+
+	dfi, err := os.Lstat(dir)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if err := os.Rename(stage, dir); err != nil {
+			return nil, fmt.Errorf("rename: %w", err)
+		}
+
+		return manifest, nil
+	case err != nil:
+		return nil, fmt.Errorf("stat: %w", err)
+	case !dfi.IsDir():
+		return nil, fmt.Errorf("%w: %s", ErrNotDir, dir)
+	}
+
+	if !overwrite {
+		for _, fi := range manifest.Files {
+			if _, err := os.Lstat(filepath.Join(dir, fi.Name)); !errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("%w: %s", fs.ErrExist, fi.Name)
+			}
+		}
+	}
+
+	if err := linkTree(dir, stage, manifest); err != nil {
+		return nil, fmt.Errorf("link existing: %w", err)
+	}
+
+	if err := copyAttrs(stage, dfi); err != nil {
+		return nil, fmt.Errorf("staging dir: %w", err)
+	}
+
+	// the old dir is left in the staging path and removed
+	if err := exchange(stage, dir); err != nil {
+		return nil, fmt.Errorf("exchange: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// linkTree hard links the entries of the dir into the staging dir except
+// the manifest files, the subdirs are created with the same attributes.
+func linkTree(dir, stage string, m *Manifest) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case rel == ".":
+			return nil
+		case filepath.Dir(rel) == "." && m.has(rel):
+			if d.IsDir() {
+				return fmt.Errorf("%w: %s", ErrNotRegular, rel)
+			}
+
+			return nil
+		case !d.IsDir():
+			return os.Link(path, filepath.Join(stage, rel))
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if err := os.Mkdir(filepath.Join(stage, rel), 0o700); err != nil {
+			return err
+		}
+
+		return copyAttrs(filepath.Join(stage, rel), fi)
+	})
+}
+
+// copyAttrs sets the mode and the owner of the dir as of the file info.
+func copyAttrs(path string, fi fs.FileInfo) error {
+	if err := os.Chmod(path, fi.Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+		return err
+	}
+
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || (int(st.Uid) == os.Geteuid() && int(st.Gid) == os.Getegid()) {
+		return nil
+	}
+
+	return os.Lchown(path, int(st.Uid), int(st.Gid))
+}
+
+func readManifest(r io.Reader) (*Manifest, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxManifestSize))
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+
+	return m, nil
+}
+
+func extractFile(dir string, hdr *tar.Header, r io.Reader) (FileInfo, error) {
+	mode := fs.FileMode(hdr.Mode).Perm()
+
+	f, err := os.OpenFile(filepath.Join(dir, hdr.Name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("create: %w", err)
+	}
+
+	defer f.Close()
+
+	// The umask can drop the bits of the mode.
+	if err := f.Chmod(mode); err != nil {
+		return FileInfo{}, fmt.Errorf("chmod: %w", err)
+	}
+
+	h := sha256.New()
+
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("copy: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return FileInfo{}, fmt.Errorf("close: %w", err)
+	}
+
+	return FileInfo{
+		Name:   hdr.Name,
+		Mode:   mode,
+		Size:   n,
+		SHA256: hexSum(h),
+	}, nil
+}
+
+// verify checks the extracted files are exactly the manifest files.
+func verify(m *Manifest, sums map[string]FileInfo) error {
+	names := make(map[string]bool, len(m.Files))
+
+	for _, fi := range m.Files {
+		if err := checkName(fi.Name); err != nil {
+			return fmt.Errorf("manifest: %w", err)
+		}
+
+		if names[fi.Name] {
+			return fmt.Errorf("%w: manifest: %s", ErrDuplicateName, fi.Name)
+		}
+
+		names[fi.Name] = true
+	}
+
+	for name := range sums {
+		if !names[name] {
+			return fmt.Errorf("%w: %s", ErrNotInManifest, name)
+		}
+	}
+
+	for _, want := range m.Files {
+		got, ok := sums[want.Name]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrMissingFile, want.Name)
+		}
+
+		if got.Size != want.Size {
+			return fmt.Errorf("%w: %s: %d, want %d", ErrSizeMismatch, want.Name, got.Size, want.Size)
+		}
+
+		if got.SHA256 != want.SHA256 {
+			return fmt.Errorf("%w: %s", ErrChecksumMismatch, want.Name)
+		}
+
+		if got.Mode != want.Mode {
+			return fmt.Errorf("%w: %s: %s, want %s", ErrModeMismatch, want.Name, got.Mode, want.Mode)
+		}
+	}
+
+	return nil
+}
+
+func (m *Manifest) has(name string) bool {
+	for _, fi := range m.Files {
+		if fi.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func checkName(name string) error {
+	if name == "" || name == "." || name == ".." || name == ManifestName || filepath.Base(name) != name {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+
+	return nil
+}
+
+func hexSum(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
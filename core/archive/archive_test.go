@@ -0,0 +1,259 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Pack_Unpack(t *testing.T) {
+	src := t.TempDir()
+	parent := t.TempDir()
+	dst := filepath.Join(parent, "brigade")
+
+	files := map[string]string{
+		"brigade.json": `{"brigade_id":"brigade1"}`,
+		".maintenance": "1700000000",
+	}
+
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(data), 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+
+	for _, name := range []string{"brigade.json", ".maintenance"} {
+		if err := w.AddFileFromDir(src, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.AddFileFromDir(src, "missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("AddFileFromDir() error = %v, want %v", err, os.ErrNotExist)
+	}
+
+	if err := w.AddFileFromDir(src, "../etc"); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("AddFileFromDir() error = %v, want %v", err, ErrInvalidName)
+	}
+
+	if err := os.Symlink("brigade.json", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.AddFileFromDir(src, "link"); !errors.Is(err, ErrNotRegular) {
+		t.Errorf("AddFileFromDir() symlink error = %v, want %v", err, ErrNotRegular)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := Unpack(bytes.NewReader(buf.Bytes()), dst, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.Files) != len(files) {
+		t.Errorf("Unpack() manifest files = %d, want %d", len(m.Files), len(files))
+	}
+
+	for name, data := range files {
+		got, err := os.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(got) != data {
+			t.Errorf("Unpack() %s = %q, want %q", name, got, data)
+		}
+
+		fi, err := os.Stat(filepath.Join(dst, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fi.Mode().Perm() != 0o640 {
+			t.Errorf("Unpack() %s mode = %s, want %s", name, fi.Mode().Perm(), os.FileMode(0o640))
+		}
+	}
+
+	if _, err := Unpack(bytes.NewReader(buf.Bytes()), dst, false); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Unpack() error = %v, want %v", err, fs.ErrExist)
+	}
+
+	// the other entries of the existing dir are kept
+	if err := os.WriteFile(filepath.Join(dst, "other"), []byte("other"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dst, "sub", "dir"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dst, "sub", "dir", "file"), []byte("file"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chmod(dst, 0o710); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dst, "brigade.json"), []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Unpack(bytes.NewReader(buf.Bytes()), dst, true); err != nil {
+		t.Fatalf("Unpack() overwrite error = %v", err)
+	}
+
+	for name, data := range map[string]string{
+		"brigade.json": files["brigade.json"],
+		"other":        "other",
+		"sub/dir/file": "file",
+		".maintenance": files[".maintenance"],
+	} {
+		if got, err := os.ReadFile(filepath.Join(dst, name)); err != nil || string(got) != data {
+			t.Errorf("Unpack() overwrite %s = %q, %v, want %q", name, got, err, data)
+		}
+	}
+
+	if fi, err := os.Stat(dst); err != nil || fi.Mode().Perm() != 0o710 {
+		t.Errorf("Unpack() dir stat = %v, %v, want mode %s", fi, err, os.FileMode(0o710))
+	}
+
+	if entries, err := os.ReadDir(parent); err != nil || len(entries) != 1 {
+		t.Errorf("Unpack() left %v, %v in the parent dir", entries, err)
+	}
+}
+
+func Test_Unpack_Tampered(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+
+	data := `{"brigade_id":"brigade1"}`
+	if err := w.AddFile("brigade.json", 0o600, int64(len(data)), strings.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := bytes.Replace(buf.Bytes(), []byte("brigade1"), []byte("brigade2"), 1)
+
+	dst := t.TempDir()
+	if _, err := Unpack(bytes.NewReader(tampered), dst, false); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Unpack() error = %v, want %v", err, ErrChecksumMismatch)
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "brigade.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Unpack() left tampered file, stat error = %v", err)
+	}
+}
+
+func Test_Unpack_NoManifest(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "brigade.json", Mode: 0o600, Size: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tw.Write([]byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Unpack(buf, t.TempDir(), false); !errors.Is(err, ErrNoManifest) {
+		t.Errorf("Unpack() error = %v, want %v", err, ErrNoManifest)
+	}
+}
+
+// rawFile is an entry of the archive made without the Writer checks.
+type rawFile struct {
+	name string
+	mode int64
+	data []byte
+}
+
+func rawArchive(t *testing.T, files ...rawFile) *bytes.Buffer {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: f.name, Mode: f.mode, Size: int64(len(f.data))}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tw.Write(f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf
+}
+
+func Test_Unpack_DuplicateManifestName(t *testing.T) {
+	data := []byte("{}")
+	fi := FileInfo{Name: "brigade.json", Mode: 0o600, Size: int64(len(data)), SHA256: "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"}
+
+	manifest, err := json.Marshal(Manifest{Files: []FileInfo{fi, fi}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := rawArchive(t,
+		rawFile{"brigade.json", 0o600, data},
+		rawFile{"extra", 0o600, data},
+		rawFile{ManifestName, 0o600, manifest},
+	)
+
+	dst := t.TempDir()
+	if _, err := Unpack(buf, dst, false); !errors.Is(err, ErrDuplicateName) {
+		t.Errorf("Unpack() error = %v, want %v", err, ErrDuplicateName)
+	}
+
+	if entries, err := os.ReadDir(dst); err != nil || len(entries) != 0 {
+		t.Errorf("Unpack() left %v, %v", entries, err)
+	}
+}
+
+func Test_Unpack_ModeMismatch(t *testing.T) {
+	data := []byte("{}")
+	manifest, err := json.Marshal(Manifest{Files: []FileInfo{{
+		Name:   "brigade.json",
+		Mode:   0o600,
+		Size:   int64(len(data)),
+		SHA256: "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := rawArchive(t,
+		rawFile{"brigade.json", 0o644, data},
+		rawFile{ManifestName, 0o600, manifest},
+	)
+
+	if _, err := Unpack(buf, t.TempDir(), false); !errors.Is(err, ErrModeMismatch) {
+		t.Errorf("Unpack() error = %v, want %v", err, ErrModeMismatch)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package archive
+
+import "errors"
+
+var errExchangeUnsupported = errors.New("atomic exchange is not supported")
+
+// exchange swaps the paths atomically.
+func exchange(_, _ string) error {
+	return errExchangeUnsupported
+}
@@ -5,5 +5,46 @@ const (
 	KeyTypeRSA      = "ssh-rsa"
 	MaxKeysFileSize = 1024 * 16 // 10 MB
 
-	PSKSize = 32
+	// PSKSize and MinPSKSize are the only allowed PSK sizes.
+	PSKSize    = 32
+	MinPSKSize = 16
+
+	// PSKDerivationHKDFSHA256 means the brigade PSK is derived
+	// from the master PSK with HKDF-SHA256.
+	PSKDerivationHKDFSHA256 = "hkdf-sha256"
+
+	// PayloadFormatTar means the payload is a tar archive
+	// of the brigade directory files. Empty means brigade.json as is.
+	PayloadFormatTar = "tar"
+	// PayloadFormatJSONPatch means the payload is a JSON patch style
+	// delta against the base snapshot of the incremental snapshot.
+	PayloadFormatJSONPatch = "json-patch"
+
+	// Payload compression algorithms. Empty means gzip.
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+
+	// DigestSHA256 means the payload is framed and has
+	// the SHA-256 digest of the plaintext in the trailer.
+	DigestSHA256 = "sha256"
+
+	// Envelope format versions. EnvelopeVersion1 is the format
+	// before the version field, so the absent version means it.
+	EnvelopeVersion1 = 1
+	EnvelopeVersion2 = 2
+	EnvelopeVersion3 = 3
+	EnvelopeVersion4 = 4
+	// EnvelopeVersion is a version of the envelopes made by the snapshot.
+	EnvelopeVersion = EnvelopeVersion4
+
+	// Envelope encodings. Empty means JSON.
+	EncodingJSON   = "json"
+	EncodingBinary = "binary"
+
+	// Record types of the streamed snapshots of the host.
+	RecordTypeDiscovered = "discovered"
+	RecordTypeSnap       = "snap"
+	RecordTypeError      = "error"
+	RecordTypeSummary    = "summary"
 )
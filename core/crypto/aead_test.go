@@ -0,0 +1,54 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func Test_SealAES256GCM_OpenAES256GCM(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, AES256KeySize)
+	data := []byte("Lorem ipsum dolor sit amet.")
+	ad := []byte("reference")
+
+	sealed, err := SealAES256GCM(key, data, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(sealed, data) {
+		t.Fatal("SealAES256GCM() leaks the plaintext")
+	}
+
+	again, err := SealAES256GCM(key, data, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(sealed, again) {
+		t.Error("SealAES256GCM() reuses the nonce")
+	}
+
+	got, err := OpenAES256GCM(key, sealed, ad)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("OpenAES256GCM() = %q, %v, want %q", got, err, data)
+	}
+
+	flipped := bytes.Clone(sealed)
+	flipped[len(flipped)-1] ^= 1
+
+	for name, tt := range map[string]struct {
+		key, data, ad []byte
+		err           error
+	}{
+		"tampered":  {key: key, data: flipped, ad: ad, err: ErrInvalidCiphertext},
+		"other ad":  {key: key, data: sealed, ad: []byte("other"), err: ErrInvalidCiphertext},
+		"other key": {key: bytes.Repeat([]byte{0x43}, AES256KeySize), data: sealed, ad: ad, err: ErrInvalidCiphertext},
+		"truncated": {key: key, data: sealed[:10], ad: ad, err: ErrInvalidCiphertext},
+		"short key": {key: key[:16], data: sealed, ad: ad, err: ErrInvalidKeySize},
+	} {
+		if _, err := OpenAES256GCM(tt.key, tt.data, tt.ad); !errors.Is(err, tt.err) {
+			t.Errorf("%s: OpenAES256GCM() error = %v, want %v", name, err, tt.err)
+		}
+	}
+}
@@ -22,6 +22,8 @@ const (
 	OpenSSLPDKF2Iter = 10000
 	// OpenSSLSaltedPrefix is the prefix used by OpenSSL.
 	OpenSSLSaltedPrefix = "Salted__"
+	// StreamBufferSize is the size of the read buffer, multiple of the AES block size.
+	StreamBufferSize = 32 * 1024
 )
 
 // Errors
@@ -56,30 +58,30 @@ func EncryptAES256CBC(r io.Reader, w io.Writer, secret []byte) error {
 	// encrypt
 	mode := cipher.NewCBCEncrypter(block, iv)
 
-	for {
-		rbuf := make([]byte, aes.BlockSize)
+	rbuf := make([]byte, StreamBufferSize)
 
+	for {
 		n, err := io.ReadFull(r, rbuf)
 		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 			return fmt.Errorf("read full: %w", err)
 		}
 
-		if n != aes.BlockSize {
-			rbuf, err = pkcs7pad(rbuf[:n], aes.BlockSize)
+		wbuf := rbuf[:n]
+
+		if n != len(rbuf) {
+			wbuf, err = pkcs7pad(wbuf, aes.BlockSize)
 			if err != nil {
 				return fmt.Errorf("pkcs7pad: %w", err)
 			}
 		}
 
-		wbuf := make([]byte, aes.BlockSize)
-
-		mode.CryptBlocks(wbuf, rbuf)
+		mode.CryptBlocks(wbuf, wbuf)
 
 		if _, err := w.Write(wbuf); err != nil {
 			return fmt.Errorf("write: %w", err)
 		}
 
-		if n != aes.BlockSize {
+		if n != len(rbuf) {
 			break
 		}
 	}
@@ -110,50 +112,53 @@ func DecryptAES256CBC(r io.Reader, w io.Writer, secret []byte) error {
 	// decrypt
 	mode := cipher.NewCBCDecrypter(block, iv)
 
-	prev := []byte(nil) // previous block
+	rbuf := make([]byte, StreamBufferSize)
+	last := make([]byte, 0, aes.BlockSize) // last decrypted block, holds the padding
+	total := 0
 
 	for {
-		rbuf := make([]byte, aes.BlockSize)
-
 		n, err := io.ReadFull(r, rbuf)
 		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 			return fmt.Errorf("read full: %w", err)
 		}
 
-		if n > 0 && n != aes.BlockSize {
-			return fmt.Errorf("%w: block size: %d", io.ErrUnexpectedEOF, n)
+		if n%aes.BlockSize != 0 {
+			return fmt.Errorf("%w: block size: %d", io.ErrUnexpectedEOF, n%aes.BlockSize)
 		}
 
-		if n == 0 && prev == nil {
-			return fmt.Errorf("%w: %d", ErrEmptyData, n)
-		}
-
-		wbuf := []byte(nil) // decrypted block
+		if n > 0 {
+			mode.CryptBlocks(rbuf[:n], rbuf[:n])
 
-		if prev != nil {
-			wbuf = make([]byte, aes.BlockSize)
-			mode.CryptBlocks(wbuf, prev)
-		}
+			if _, err := w.Write(last); err != nil {
+				return fmt.Errorf("write: %w", err)
+			}
 
-		switch n {
-		case aes.BlockSize:
-			prev = rbuf
-		case 0:
-			wbuf, err = pkcs7strip(wbuf, aes.BlockSize)
-			if err != nil {
-				return fmt.Errorf("pkcs7strip: %w", err)
+			if _, err := w.Write(rbuf[:n-aes.BlockSize]); err != nil {
+				return fmt.Errorf("write: %w", err)
 			}
-		}
 
-		if _, err := w.Write(wbuf); err != nil {
-			return fmt.Errorf("write: %w", err)
+			last = append(last[:0], rbuf[n-aes.BlockSize:n]...)
+			total += n
 		}
 
-		if n != aes.BlockSize {
+		if n != len(rbuf) {
 			break
 		}
 	}
 
+	if total == 0 {
+		return fmt.Errorf("%w: %d", ErrEmptyData, total)
+	}
+
+	last, err = pkcs7strip(last, aes.BlockSize)
+	if err != nil {
+		return fmt.Errorf("pkcs7strip: %w", err)
+	}
+
+	if _, err := w.Write(last); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
 	return nil
 }
 
@@ -43,6 +43,18 @@ func Test_EncryptAES256CBC_DecryptAES256CBC(t *testing.T) {
 			secret:  "my super secret password",
 			wantErr: false,
 		},
+		{
+			name:    "stream buffer size data",
+			data:    strings.Repeat("L", StreamBufferSize),
+			secret:  "my super secret password",
+			wantErr: false,
+		},
+		{
+			name:    "multiple stream buffers data",
+			data:    strings.Repeat("Lorem ipsum dui.", StreamBufferSize/8) + "tail",
+			secret:  "my super secret password",
+			wantErr: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -15,6 +15,11 @@ var (
 	ErrEmptySecret   = errors.New("empty secret")
 )
 
+var (
+	ErrInvalidKeySize    = errors.New("invalid key size")
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+)
+
 var (
 	ErrDecodePEM = errors.New("unable to decode pem file")
 	ErrNoRSAKey  = errors.New("not an RSA private key")
@@ -0,0 +1,116 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	ErrDuplicateKey     = errors.New("duplicate key")
+	ErrUnusableKeysFile = errors.New("keys file is unusable")
+)
+
+// KeyInfo is an inventory entry of the authorized_keys format data.
+type KeyInfo struct {
+	// Line is a line number of the key, starting with 1.
+	Line        int    `json:"line"`
+	Type        string `json:"type,omitempty"`
+	Bits        int    `json:"bits,omitempty"`
+	FingerPrint string `json:"fingerprint,omitempty"`
+	Comment     string `json:"comment,omitempty"`
+	// Usable means the snapshot uses the key, other types are skipped.
+	Usable bool `json:"usable"`
+	// Error is a problem of the key line.
+	Error string `json:"error,omitempty"`
+}
+
+// KeysInventory returns the entries of every key line of the
+// authorized_keys format data. The unparsable lines are reported,
+// not skipped, the usable keys are the RSA ones, as GetRSAPublicKeysList
+// takes them. The snapshot reads the whole file, so if GetRSAPublicKeysList
+// fails none of the keys is usable and the entries are returned with
+// the ErrUnusableKeysFile error.
+func KeysInventory(data []byte) ([]*KeyInfo, error) {
+	list := []*KeyInfo{}
+	seen := map[string]bool{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		info := &KeyInfo{Line: n}
+		list = append(list, info)
+
+		key, comment, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			info.Error = fmt.Sprintf("parse key: %s", err)
+
+			continue
+		}
+
+		info.Type = key.Type()
+		info.Bits = keyBits(key)
+		info.FingerPrint = ssh.FingerprintSHA256(key)
+		info.Comment = comment
+
+		if key.Type() == snapCore.KeyTypeRSA {
+			if _, err := ConvSSHPubKeyToRSAPubKey(key); err != nil {
+				info.Error = fmt.Sprintf("extract rsa key: %s", err)
+			} else {
+				info.Usable = true
+			}
+		}
+
+		if seen[info.FingerPrint] {
+			info.Error = ErrDuplicateKey.Error()
+		}
+
+		seen[info.FingerPrint] = true
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan: %w", err)
+	}
+
+	if _, err := GetRSAPublicKeysList(data); err != nil {
+		for _, info := range list {
+			info.Usable = false
+		}
+
+		return list, fmt.Errorf("%w: %w", ErrUnusableKeysFile, err)
+	}
+
+	return list, nil
+}
+
+// keyBits returns the key size in bits, zero if it is unknown.
+func keyBits(key ssh.PublicKey) int {
+	cryptoKey, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return 0
+	}
+
+	switch k := cryptoKey.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		return k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return len(k) * 8
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,90 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func Test_KeysInventory(t *testing.T) {
+	first, _, _ := bytes.Cut(AuthoritiesKeysSample, []byte("\n"))
+
+	data := bytes.Join([][]byte{AuthoritiesKeysSample, []byte("# comment\n\nssh-rsa garbage broken\n"), first}, nil)
+
+	list, err := KeysInventory(data)
+	if err != nil {
+		t.Fatalf("KeysInventory() error = %v", err)
+	}
+
+	type want struct {
+		typ     string
+		bits    int
+		comment string
+		usable  bool
+		err     bool
+	}
+
+	wants := []want{
+		{typ: "ssh-rsa", bits: 4096, comment: "auth1", usable: true},
+		{typ: "ssh-rsa", bits: 4096, comment: "auth2", usable: true},
+		{typ: "ecdsa-sha2-nistp256", bits: 256, comment: "realm3"},
+		{typ: "ssh-ed25519", bits: 256, comment: "realm4"},
+		// the ed25519 key with the ssh-rsa marker
+		{typ: "ssh-ed25519", bits: 256, comment: "realm4", err: true},
+		{err: true},
+		{typ: "ssh-rsa", bits: 4096, comment: "auth1", usable: true, err: true},
+	}
+
+	if len(list) != len(wants) {
+		t.Fatalf("KeysInventory() got %d keys, want %d", len(list), len(wants))
+	}
+
+	for i, w := range wants {
+		got := list[i]
+
+		if got.Type != w.typ || got.Comment != w.comment || got.Usable != w.usable || (got.Error != "") != w.err {
+			t.Errorf("KeysInventory() key %d = %+v, want %+v", i, got, w)
+		}
+
+		if w.bits != 0 && got.Bits != w.bits {
+			t.Errorf("KeysInventory() key %d bits = %d, want %d", i, got.Bits, w.bits)
+		}
+
+		if !w.err && got.FingerPrint == "" {
+			t.Errorf("KeysInventory() key %d has no fingerprint", i)
+		}
+	}
+
+	if list[6].FingerPrint != list[0].FingerPrint {
+		t.Errorf("KeysInventory() duplicate fingerprint = %s, want %s", list[6].FingerPrint, list[0].FingerPrint)
+	}
+}
+
+func Test_KeysInventory_MalformedLast(t *testing.T) {
+	data := append(bytes.Clone(AuthoritiesKeysSample), "ssh-rsa AAAA broken\n"...)
+
+	if _, err := GetRSAPublicKeysList(data); err == nil {
+		t.Fatal("GetRSAPublicKeysList() error = nil, want the malformed key error")
+	}
+
+	// the snapshot fails on the file, so none of the keys is usable
+	list, err := KeysInventory(data)
+	if !errors.Is(err, ErrUnusableKeysFile) {
+		t.Fatalf("KeysInventory() error = %v, want %v", err, ErrUnusableKeysFile)
+	}
+
+	last := list[len(list)-1]
+	if last.Error == "" {
+		t.Errorf("KeysInventory() last key = %+v, want the key with the error", last)
+	}
+
+	for _, info := range list {
+		if info.Usable {
+			t.Errorf("KeysInventory() line %d usable, want not usable", info.Line)
+		}
+	}
+
+	if list[0].Error != "" || list[1].Error != "" {
+		t.Errorf("KeysInventory() = %+v, %+v, want the keys without the errors", list[0], list[1])
+	}
+}
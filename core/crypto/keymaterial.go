@@ -0,0 +1,67 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// KeyMaterial is the public keys of the snapshot resolved once by the
+// supervisor and passed to the brigade snapshot processes. The keys are
+// in the authorized_keys format.
+type KeyMaterial struct {
+	RealmKey      string `json:"realm_key"`
+	AuthorityKeys string `json:"authority_keys"`
+}
+
+// NewKeyMaterial returns the key material of the realm and authorities keys.
+func NewKeyMaterial(realmKey *rsa.PublicKey, authKeys []*RSAPublicKey) (*KeyMaterial, error) {
+	realm, err := marshalRSAPublicKey(realmKey)
+	if err != nil {
+		return nil, fmt.Errorf("realm key: %w", err)
+	}
+
+	auths := &bytes.Buffer{}
+
+	for _, key := range authKeys {
+		auth, err := marshalRSAPublicKey(key.Key)
+		if err != nil {
+			return nil, fmt.Errorf("authority key %s: %w", key.FingerPrint, err)
+		}
+
+		auths.Write(auth)
+	}
+
+	return &KeyMaterial{RealmKey: string(realm), AuthorityKeys: auths.String()}, nil
+}
+
+// Keys returns the realm key checked against the fingerprint
+// and the authorities keys.
+func (km *KeyMaterial) Keys(realmFP string) (*rsa.PublicKey, []*RSAPublicKey, error) {
+	realmKey, err := GetPublicRSAKeyByFingerprint([]byte(km.RealmKey), realmFP)
+	if err != nil {
+		return nil, nil, fmt.Errorf("realm key: %w", err)
+	}
+
+	authKeys, err := GetRSAPublicKeysList([]byte(km.AuthorityKeys))
+	if err != nil {
+		return nil, nil, fmt.Errorf("authority keys: %w", err)
+	}
+
+	return realmKey, authKeys, nil
+}
+
+func marshalRSAPublicKey(key *rsa.PublicKey) ([]byte, error) {
+	if key == nil {
+		return nil, ErrKeyNotFound
+	}
+
+	pub, err := ssh.NewPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.MarshalAuthorizedKey(pub), nil
+}
@@ -0,0 +1,52 @@
+package crypto
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_KeyMaterial(t *testing.T) {
+	const realmFP = "SHA256:g3+OoyULfxUvOr/JTcpY0ZgIajOqPq+BU8Eff6wHMwk"
+
+	realmKey, err := GetPublicRSAKeyByFingerprint(RealmsKeysSample, realmFP)
+	if err != nil {
+		t.Fatalf("realm key: %s", err)
+	}
+
+	authKeys, err := GetRSAPublicKeysList(AuthoritiesKeysSample)
+	if err != nil {
+		t.Fatalf("authority keys: %s", err)
+	}
+
+	km, err := NewKeyMaterial(realmKey, authKeys)
+	if err != nil {
+		t.Fatalf("NewKeyMaterial() error = %v", err)
+	}
+
+	gotRealm, gotAuths, err := km.Keys(realmFP)
+	if err != nil {
+		t.Fatalf("Keys() error = %v", err)
+	}
+
+	if !gotRealm.Equal(realmKey) {
+		t.Errorf("Keys() realm key mismatch")
+	}
+
+	if len(gotAuths) != len(authKeys) {
+		t.Fatalf("Keys() got %d authority keys, want %d", len(gotAuths), len(authKeys))
+	}
+
+	for i, key := range gotAuths {
+		if key.FingerPrint != authKeys[i].FingerPrint || !key.Key.Equal(authKeys[i].Key) {
+			t.Errorf("Keys() authority key %d mismatch", i)
+		}
+	}
+
+	if _, _, err := km.Keys("SHA256:Mq1Y8F3nVzevtJD4bh6ULKTXTrCaPdLijx1TpkwWuBc"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Keys() other fingerprint error = %v, want %v", err, ErrKeyNotFound)
+	}
+
+	if _, err := NewKeyMaterial(nil, authKeys); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("NewKeyMaterial() no realm key error = %v, want %v", err, ErrKeyNotFound)
+	}
+}
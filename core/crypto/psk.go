@@ -0,0 +1,69 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// BrigadePSKSalt is a HKDF salt for the brigade PSK derivation.
+const BrigadePSKSalt = "vpngen-keydesk-snap-brigade-psk"
+
+// DeriveBrigadePSK derives the brigade PSK from the master PSK.
+// Tag, BrigadeID and GlobalSnapAt are used as a context,
+// so the brigade PSK reveals nothing about the master PSK
+// and about the other brigades PSKs.
+func DeriveBrigadePSK(master []byte, tag string, id string, gt time.Time) ([]byte, error) {
+	if len(master) == 0 {
+		return nil, ErrEmptySecret
+	}
+
+	info := make([]byte, 0, len(tag)+1+len(id)+1+8)
+	info = append(info, tag...)
+	info = append(info, 0)
+	info = append(info, id...)
+	info = append(info, 0)
+	info = binary.BigEndian.AppendUint64(info, uint64(gt.Unix()))
+
+	psk := make([]byte, len(master))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, master, []byte(BrigadePSKSalt), info), psk); err != nil {
+		return nil, fmt.Errorf("hkdf: %w", err)
+	}
+
+	return psk, nil
+}
+
+// Salts of the brigade keys derived from the master PSK.
+const (
+	ReferenceKeySalt = "vpngen-keydesk-snap-reference"
+	RedactionKeySalt = "vpngen-keydesk-snap-redaction"
+)
+
+// DeriveReferenceKey derives the key of the local incremental snapshot
+// reference from the host reference key and the BrigadeID.
+func DeriveReferenceKey(master []byte, id string) ([]byte, error) {
+	return deriveBrigadeKey(master, ReferenceKeySalt, id)
+}
+
+// DeriveRedactionKey derives the HMAC key of the redacted
+// values from the master PSK and the BrigadeID.
+func DeriveRedactionKey(master []byte, id string) ([]byte, error) {
+	return deriveBrigadeKey(master, RedactionKeySalt, id)
+}
+
+func deriveBrigadeKey(master []byte, salt string, id string) ([]byte, error) {
+	if len(master) == 0 {
+		return nil, ErrEmptySecret
+	}
+
+	key := make([]byte, len(master))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, master, []byte(salt), []byte(id)), key); err != nil {
+		return nil, fmt.Errorf("hkdf: %w", err)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,112 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func Test_DeriveBrigadePSK(t *testing.T) {
+	master := []byte("0123456789abcdef0123456789abcdef")
+	gt := time.Unix(1700000000, 0)
+
+	psk, err := DeriveBrigadePSK(master, "tag", "brigade1", gt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(psk) != len(master) {
+		t.Errorf("DeriveBrigadePSK() len = %d, want %d", len(psk), len(master))
+	}
+
+	if bytes.Equal(psk, master) {
+		t.Error("DeriveBrigadePSK() returns the master PSK")
+	}
+
+	again, err := DeriveBrigadePSK(master, "tag", "brigade1", gt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(psk, again) {
+		t.Error("DeriveBrigadePSK() is not deterministic")
+	}
+
+	others := []struct {
+		name string
+		tag  string
+		id   string
+		gt   time.Time
+	}{
+		{name: "other tag", tag: "tag2", id: "brigade1", gt: gt},
+		{name: "other brigade", tag: "tag", id: "brigade2", gt: gt},
+		{name: "other time", tag: "tag", id: "brigade1", gt: gt.Add(time.Second)},
+		{name: "shifted separator", tag: "tagb", id: "rigade1", gt: gt},
+	}
+
+	for _, tt := range others {
+		t.Run(tt.name, func(t *testing.T) {
+			other, err := DeriveBrigadePSK(master, tt.tag, tt.id, tt.gt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if bytes.Equal(psk, other) {
+				t.Errorf("DeriveBrigadePSK() collision for %s", tt.name)
+			}
+		})
+	}
+
+	if _, err := DeriveBrigadePSK(nil, "tag", "brigade1", gt); err == nil {
+		t.Error("DeriveBrigadePSK() with empty master PSK: want error")
+	}
+}
+
+func Test_DeriveReferenceKey(t *testing.T) {
+	master := []byte("0123456789abcdef0123456789abcdef")
+
+	key, err := DeriveReferenceKey(master, "brigade1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	psk, err := DeriveBrigadePSK(master, "", "brigade1", time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(key, master) || bytes.Equal(key, psk) {
+		t.Error("DeriveReferenceKey() returns the master or the brigade PSK")
+	}
+
+	other, err := DeriveReferenceKey(master, "brigade2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(key, other) {
+		t.Error("DeriveReferenceKey() collision for other brigade")
+	}
+
+	if _, err := DeriveReferenceKey(nil, "brigade1"); err == nil {
+		t.Error("DeriveReferenceKey() with empty master PSK: want error")
+	}
+}
+
+func Test_DeriveRedactionKey(t *testing.T) {
+	master := []byte("0123456789abcdef0123456789abcdef")
+
+	key, err := DeriveRedactionKey(master, "brigade1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := DeriveReferenceKey(master, "brigade1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(key, ref) {
+		t.Error("DeriveRedactionKey() equals the reference key")
+	}
+}
@@ -117,3 +117,14 @@ func ReadPrivateSSHKeyFile(path string) (*rsa.PrivateKey, error) {
 
 	return rsaKey, nil
 }
+
+// FingerprintRSAPublicKey returns the SHA256 fingerprint of the RSA public key
+// in the same form as ssh-keygen prints it.
+func FingerprintRSAPublicKey(key *rsa.PublicKey) (string, error) {
+	sshKey, err := ssh.NewPublicKey(key)
+	if err != nil {
+		return "", fmt.Errorf("new ssh public key: %w", err)
+	}
+
+	return ssh.FingerprintSHA256(sshKey), nil
+}
@@ -0,0 +1,378 @@
+// Package delta implements the JSON patch style deltas
+// between two versions of the brigade JSON for incremental snapshots.
+package delta
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// JSON patch (RFC 6902) operations subset.
+const (
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpReplace = "replace"
+)
+
+var (
+	ErrInvalidOp         = fmt.Errorf("invalid op")
+	ErrInvalidPath       = fmt.Errorf("invalid path")
+	ErrBaseMismatch      = fmt.Errorf("base digest mismatch")
+	ErrResultMismatch    = fmt.Errorf("result digest mismatch")
+	ErrTrailingJSONValue = fmt.Errorf("trailing data after json value")
+)
+
+// Op is a single patch operation.
+type Op struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Patch is a delta between the base and the result documents.
+// The digests are the canonical form digests, see Digest.
+type Patch struct {
+	BaseDigest   string `json:"base_digest"`
+	ResultDigest string `json:"result_digest"`
+	Ops          []Op   `json:"ops"`
+}
+
+// Canonical returns the canonical form of the JSON document:
+// compact, with sorted object keys and numbers as is.
+func Canonical(data []byte) ([]byte, error) {
+	v, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
+
+// Digest returns the base64 SHA-256 digest of the canonical document.
+func Digest(canonical []byte) string {
+	sum := sha256.Sum256(canonical)
+
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Diff makes the patch which turns the base document into the result one.
+// Arrays are compared element by element, the tail is added or removed.
+func Diff(base, result []byte) (*Patch, error) {
+	a, err := decode(base)
+	if err != nil {
+		return nil, fmt.Errorf("base: %w", err)
+	}
+
+	b, err := decode(result)
+	if err != nil {
+		return nil, fmt.Errorf("result: %w", err)
+	}
+
+	p := &Patch{Ops: []Op{}}
+
+	if err := diff(p, "", a, b); err != nil {
+		return nil, err
+	}
+
+	if p.BaseDigest, err = digestOf(a); err != nil {
+		return nil, fmt.Errorf("base: %w", err)
+	}
+
+	if p.ResultDigest, err = digestOf(b); err != nil {
+		return nil, fmt.Errorf("result: %w", err)
+	}
+
+	return p, nil
+}
+
+// Apply applies the patch to the base document
+// and returns the result document in the canonical form.
+// Both base and result digests are verified.
+func Apply(base []byte, p *Patch) ([]byte, error) {
+	doc, err := decode(base)
+	if err != nil {
+		return nil, fmt.Errorf("base: %w", err)
+	}
+
+	digest, err := digestOf(doc)
+	if err != nil {
+		return nil, fmt.Errorf("base: %w", err)
+	}
+
+	if digest != p.BaseDigest {
+		return nil, ErrBaseMismatch
+	}
+
+	for i, op := range p.Ops {
+		var value any
+
+		if op.Op != OpRemove {
+			if value, err = decode(op.Value); err != nil {
+				return nil, fmt.Errorf("op %d: value: %w", i, err)
+			}
+		}
+
+		tokens, err := parsePath(op.Path)
+		if err != nil {
+			return nil, fmt.Errorf("op %d: %w", i, err)
+		}
+
+		if doc, err = apply(doc, tokens, op.Op, value); err != nil {
+			return nil, fmt.Errorf("op %d: %s %s: %w", i, op.Op, op.Path, err)
+		}
+	}
+
+	result, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+
+	if Digest(result) != p.ResultDigest {
+		return nil, ErrResultMismatch
+	}
+
+	return result, nil
+}
+
+func diff(p *Patch, path string, a, b any) error {
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+
+		for _, k := range sortedKeys(av) {
+			if _, ok := bv[k]; !ok {
+				p.Ops = append(p.Ops, Op{Op: OpRemove, Path: path + "/" + escape(k)})
+			}
+		}
+
+		for _, k := range sortedKeys(bv) {
+			if _, ok := av[k]; !ok {
+				if err := addOp(p, OpAdd, path+"/"+escape(k), bv[k]); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			if err := diff(p, path+"/"+escape(k), av[k], bv[k]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case []any:
+		bv, ok := b.([]any)
+		if !ok {
+			break
+		}
+
+		for i := range min(len(av), len(bv)) {
+			if err := diff(p, path+"/"+strconv.Itoa(i), av[i], bv[i]); err != nil {
+				return err
+			}
+		}
+
+		for i := len(av) - 1; i >= len(bv); i-- {
+			p.Ops = append(p.Ops, Op{Op: OpRemove, Path: path + "/" + strconv.Itoa(i)})
+		}
+
+		for i := len(av); i < len(bv); i++ {
+			if err := addOp(p, OpAdd, path+"/"+strconv.Itoa(i), bv[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	default:
+		// both maps and slices are handled above, so the scalars are comparable
+		if a == b {
+			return nil
+		}
+	}
+
+	return addOp(p, OpReplace, path, b)
+}
+
+func addOp(p *Patch, op, path string, v any) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal %s: %w", path, err)
+	}
+
+	p.Ops = append(p.Ops, Op{Op: op, Path: path, Value: value})
+
+	return nil
+}
+
+func apply(node any, tokens []string, op string, value any) (any, error) {
+	if len(tokens) == 0 {
+		switch op {
+		case OpAdd, OpReplace:
+			return value, nil
+		case OpRemove:
+			return nil, ErrInvalidPath
+		default:
+			return nil, ErrInvalidOp
+		}
+	}
+
+	key, last := tokens[0], len(tokens) == 1
+
+	switch n := node.(type) {
+	case map[string]any:
+		child, ok := n[key]
+
+		if !last {
+			if !ok {
+				return nil, ErrInvalidPath
+			}
+
+			child, err := apply(child, tokens[1:], op, value)
+			if err != nil {
+				return nil, err
+			}
+
+			n[key] = child
+
+			return n, nil
+		}
+
+		switch op {
+		case OpAdd:
+			n[key] = value
+		case OpReplace:
+			if !ok {
+				return nil, ErrInvalidPath
+			}
+
+			n[key] = value
+		case OpRemove:
+			if !ok {
+				return nil, ErrInvalidPath
+			}
+
+			delete(n, key)
+		default:
+			return nil, ErrInvalidOp
+		}
+
+		return n, nil
+	case []any:
+		i, err := index(key, len(n), last && op == OpAdd)
+		if err != nil {
+			return nil, err
+		}
+
+		if !last {
+			child, err := apply(n[i], tokens[1:], op, value)
+			if err != nil {
+				return nil, err
+			}
+
+			n[i] = child
+
+			return n, nil
+		}
+
+		switch op {
+		case OpAdd:
+			return slices.Insert(n, i, value), nil
+		case OpReplace:
+			n[i] = value
+
+			return n, nil
+		case OpRemove:
+			return slices.Delete(n, i, i+1), nil
+		default:
+			return nil, ErrInvalidOp
+		}
+	default:
+		return nil, ErrInvalidPath
+	}
+}
+
+// index parses the array index, "-" and len are allowed for add only.
+func index(token string, size int, add bool) (int, error) {
+	if token == "-" && add {
+		return size, nil
+	}
+
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, ErrInvalidPath
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > size || (i == size && !add) {
+		return 0, ErrInvalidPath
+	}
+
+	return i, nil
+}
+
+// parsePath splits the JSON pointer (RFC 6901) into the tokens.
+func parsePath(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	if path[0] != '/' {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+	}
+
+	tokens := strings.Split(path[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+
+	return tokens, nil
+}
+
+func escape(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
+
+func digestOf(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("marshal: %w", err)
+	}
+
+	return Digest(data), nil
+}
+
+// decode decodes the JSON document keeping the numbers as is.
+func decode(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+
+	if dec.More() {
+		return nil, ErrTrailingJSONValue
+	}
+
+	return v, nil
+}
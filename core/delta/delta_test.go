@@ -0,0 +1,158 @@
+package delta
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	snapCrypto "github.com/vpngen/keydesk-snap/core/crypto"
+)
+
+func Test_DiffApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		base   string
+		result string
+		ops    int
+	}{
+		{name: "equal", base: `{"a":1,"b":[1,2]}`, result: `{"b":[1,2],"a":1}`, ops: 0},
+		{name: "replace", base: `{"a":1}`, result: `{"a":"1"}`, ops: 1},
+		{name: "add remove", base: `{"a":1,"b":2}`, result: `{"a":1,"c":{"d":null}}`, ops: 2},
+		{name: "array grow", base: `{"u":[{"id":1}]}`, result: `{"u":[{"id":1},{"id":2},{"id":3}]}`, ops: 2},
+		{name: "array shrink", base: `{"u":[1,2,3,4]}`, result: `{"u":[1,5]}`, ops: 3},
+		{name: "nested", base: `{"u":[{"id":1,"q":{"n":10}}]}`, result: `{"u":[{"id":1,"q":{"n":11}}]}`, ops: 1},
+		{name: "escaped keys", base: `{"a/b":1,"c~d":2}`, result: `{"a/b":2,"c~d":3}`, ops: 2},
+		{name: "root", base: `[1]`, result: `{"a":1}`, ops: 1},
+		{name: "big numbers", base: `{"n":12345678901234567890}`, result: `{"n":12345678901234567891}`, ops: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Diff([]byte(tt.base), []byte(tt.result))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(p.Ops) != tt.ops {
+				t.Errorf("Diff() ops = %+v, want %d ops", p.Ops, tt.ops)
+			}
+
+			got, err := Apply([]byte(tt.base), p)
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+
+			want, err := Canonical([]byte(tt.result))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != string(want) {
+				t.Errorf("Apply() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func Test_Apply_Mismatch(t *testing.T) {
+	p, err := Diff([]byte(`{"a":1}`), []byte(`{"a":2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Apply([]byte(`{"a":3}`), p); !errors.Is(err, ErrBaseMismatch) {
+		t.Errorf("Apply() error = %v, want %v", err, ErrBaseMismatch)
+	}
+
+	p.Ops[0].Value = []byte(`4`)
+
+	if _, err := Apply([]byte(`{"a":1}`), p); !errors.Is(err, ErrResultMismatch) {
+		t.Errorf("Apply() error = %v, want %v", err, ErrResultMismatch)
+	}
+
+	p.Ops[0] = Op{Op: OpRemove, Path: "/b"}
+
+	if _, err := Apply([]byte(`{"a":1}`), p); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("Apply() error = %v, want %v", err, ErrInvalidPath)
+	}
+}
+
+func Test_Reference(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), ReferenceFileName)
+	key := bytes.Repeat([]byte{0x42}, ReferenceKeySize)
+
+	ref, err := NewReference("tag1", []byte(`{ "b": 2, "a": 1 }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteReference(filename, key, ref); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Mode().Perm()&0o077 != 0 {
+		t.Errorf("WriteReference() mode = %s, want owner only", fi.Mode())
+	}
+
+	got, err := ReadReference(filename, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Tag != ref.Tag || got.Digest != ref.Digest || string(got.Data) != `{"a":1,"b":2}` {
+		t.Errorf("ReadReference() = %+v, want %+v", got, ref)
+	}
+
+	if _, err := ReadReference(filename, bytes.Repeat([]byte{0x43}, ReferenceKeySize)); err == nil {
+		t.Error("ReadReference() with wrong key: want error")
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data[len(data)/2] ^= 1
+
+	if err := os.WriteFile(filename, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadReference(filename, key); !errors.Is(err, snapCrypto.ErrInvalidCiphertext) {
+		t.Errorf("ReadReference() of tampered file error = %v, want %v", err, snapCrypto.ErrInvalidCiphertext)
+	}
+}
+
+func Test_ReadReferenceKey(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), ReferenceKeyFileName)
+
+	if _, err := ReadReferenceKey(filename); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadReferenceKey() of missing file error = %v, want %v", err, fs.ErrNotExist)
+	}
+
+	key := bytes.Repeat([]byte{0x42}, ReferenceKeySize)
+	if err := os.WriteFile(filename, key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadReferenceKey(filename)
+	if err != nil || !bytes.Equal(got, key) {
+		t.Errorf("ReadReferenceKey() = %x, %v, want %x", got, err, key)
+	}
+
+	if err := os.WriteFile(filename, []byte("short"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadReferenceKey(filename); !errors.Is(err, ErrReferenceKey) {
+		t.Errorf("ReadReferenceKey() error = %v, want %v", err, ErrReferenceKey)
+	}
+}
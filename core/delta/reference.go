@@ -0,0 +1,134 @@
+package delta
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	snapCrypto "github.com/vpngen/keydesk-snap/core/crypto"
+	snapHelper "github.com/vpngen/keydesk-snap/core/helper"
+)
+
+const (
+	// ReferenceFileName is a name of the local reference file in the brigade dir.
+	ReferenceFileName = ".snapshot-reference"
+	// MaxReferenceFileSize is a maximum reference file size in KB.
+	MaxReferenceFileSize = 1024 * 1024 // 1 GB
+	// PendingReferenceSuffix is a suffix of the reference file of the last
+	// snapshot, which is not yet confirmed as delivered to the realm.
+	PendingReferenceSuffix = ".pending"
+	// ReferenceKeyFileName is a name of the host reference key file in the
+	// config dir. The file is root owned and readable by the fetch only,
+	// the brigades get the derived keys, see crypto.DeriveReferenceKey.
+	ReferenceKeyFileName = "reference.key"
+	// ReferenceKeySize is a size of the host reference key.
+	ReferenceKeySize = 32
+	// referenceAD is the additional data of the sealed reference.
+	referenceAD = "vpngen-keydesk-snap-reference"
+)
+
+var (
+	ErrReferenceDigest = fmt.Errorf("reference digest mismatch")
+	ErrReferenceKey    = fmt.Errorf("invalid reference key")
+)
+
+// Reference is the canonical plaintext of the last snapshot
+// kept locally to make the next incremental snapshot against it.
+type Reference struct {
+	// Tag is the tag of the snapshot.
+	Tag string `json:"tag"`
+	// Digest is the canonical form digest, see Digest.
+	Digest string `json:"digest"`
+	// Data is the canonical form of the snapshot plaintext.
+	Data json.RawMessage `json:"data"`
+}
+
+// NewReference makes the reference from the snapshot plaintext.
+func NewReference(tag string, data []byte) (*Reference, error) {
+	canonical, err := Canonical(data)
+	if err != nil {
+		return nil, fmt.Errorf("canonical: %w", err)
+	}
+
+	return &Reference{
+		Tag:    tag,
+		Digest: Digest(canonical),
+		Data:   canonical,
+	}, nil
+}
+
+// ReadReference reads and decrypts the reference file.
+func ReadReference(filename string, key []byte) (*Reference, error) {
+	data, err := snapHelper.ReadFileSafeSize(filename, MaxReferenceFileSize)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+
+	plain, err := snapCrypto.OpenAES256GCM(key, data, []byte(referenceAD))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: %w", err)
+	}
+
+	ref := &Reference{}
+	if err := json.Unmarshal(plain, ref); err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+
+	if Digest(ref.Data) != ref.Digest {
+		return nil, ErrReferenceDigest
+	}
+
+	return ref, nil
+}
+
+// WriteReference encrypts and atomically writes the reference file.
+func WriteReference(filename string, key []byte, ref *Reference) error {
+	plain, err := json.Marshal(ref)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	sealed, err := snapCrypto.SealAES256GCM(key, plain, []byte(referenceAD))
+	if err != nil {
+		return fmt.Errorf("encrypt: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".")
+	if err != nil {
+		return fmt.Errorf("create temp: %w", err)
+	}
+
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.Write(sealed); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), filename); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+
+	return nil
+}
+
+// ReadReferenceKey reads the host reference key file. The key is
+// created on the package install, it is stable while the PSK can
+// change every run.
+func ReadReferenceKey(filename string) ([]byte, error) {
+	key, err := snapHelper.ReadFileSafeSize(filename, 1)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+
+	if len(key) != ReferenceKeySize {
+		return nil, fmt.Errorf("%w: size %d", ErrReferenceKey, len(key))
+	}
+
+	return key, nil
+}
@@ -1 +1,8 @@
 package core
+
+import "errors"
+
+var (
+	ErrInvalidTag = errors.New("invalid tag")
+	ErrTagTime    = errors.New("tag time mismatch")
+)
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"syscall"
 )
 
 // ErrFileTooBig is returned when file size is too big.
@@ -27,3 +28,14 @@ func ReadFileSafeSize(filename string, kbSize int64) ([]byte, error) {
 
 	return data, nil
 }
+
+// OpenFD returns the file of the inherited file descriptor.
+// The closed descriptor fails with syscall.EBADF.
+func OpenFD(fd int, name string) (*os.File, error) {
+	st := &syscall.Stat_t{}
+	if err := syscall.Fstat(fd, st); err != nil {
+		return nil, fmt.Errorf("fstat fd %d: %w", fd, err)
+	}
+
+	return os.NewFile(uintptr(fd), name), nil
+}
@@ -0,0 +1,29 @@
+package helper
+
+import "runtime/debug"
+
+// KeydeskModulePath is a path of the keydesk module.
+const KeydeskModulePath = "github.com/vpngen/keydesk"
+
+// ModuleVersion returns the version of the dependency module
+// the binary is built with or empty string if it is unknown.
+func ModuleVersion(path string) string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path != path {
+			continue
+		}
+
+		if dep.Replace != nil {
+			return dep.Replace.Version
+		}
+
+		return dep.Version
+	}
+
+	return ""
+}
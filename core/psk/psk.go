@@ -0,0 +1,193 @@
+package psk
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	snapHelper "github.com/vpngen/keydesk-snap/core/helper"
+)
+
+// Errors
+var (
+	ErrEmptyPSK            = errors.New("empty psk")
+	ErrInvalidEncoding     = errors.New("invalid psk encoding")
+	ErrInvalidSize         = errors.New("invalid psk size")
+	ErrTrailingData        = errors.New("trailing data after psk")
+	ErrMultipleSources     = errors.New("multiple psk sources")
+	ErrSourceNotFound      = errors.New("psk source not found")
+	ErrInsecurePermissions = errors.New("insecure psk file permissions")
+)
+
+// Exit codes for the each kind of the PSK failure.
+const (
+	ExitCodeOther               = 1
+	ExitCodeEmptyPSK            = 10
+	ExitCodeInvalidEncoding     = 11
+	ExitCodeInvalidSize         = 12
+	ExitCodeTrailingData        = 13
+	ExitCodeMultipleSources     = 14
+	ExitCodeSourceNotFound      = 15
+	ExitCodeInsecurePermissions = 16
+)
+
+const (
+	maxEncodedLen = (snapCore.PSKSize + 2) / 3 * 4
+	// optional CRLF and one more byte to detect the trailing data
+	maxReadLen = maxEncodedLen + 2 + 1
+	// PSK file must not be accessible by the group and others
+	insecurePermMask = 0o077
+)
+
+// Source describes where the PSK is read from.
+// Only one field can be set, zero Source means stdin.
+type Source struct {
+	// FD is a number of an inherited file descriptor.
+	FD int
+	// File is a path of the file readable by the owner only.
+	File string
+	// Env is a name of the environment variable.
+	Env string
+}
+
+// Read reads and strictly decodes the base64 encoded PSK from the source.
+func Read(src Source) ([]byte, error) {
+	n := 0
+	for _, set := range []bool{src.FD > 0, src.File != "", src.Env != ""} {
+		if set {
+			n++
+		}
+	}
+
+	if n > 1 {
+		return nil, ErrMultipleSources
+	}
+
+	switch {
+	case src.FD > 0:
+		f, err := snapHelper.OpenFD(src.FD, "psk-fd")
+		if err != nil {
+			if errors.Is(err, syscall.EBADF) {
+				return nil, fmt.Errorf("%w: %w", ErrSourceNotFound, err)
+			}
+
+			return nil, err
+		}
+
+		defer f.Close()
+
+		return readFrom(f)
+	case src.File != "":
+		return readFile(src.File)
+	case src.Env != "":
+		value, ok := os.LookupEnv(src.Env)
+		if !ok {
+			return nil, fmt.Errorf("%w: env %s", ErrSourceNotFound, src.Env)
+		}
+
+		// don't leak the PSK to the child processes
+		os.Unsetenv(src.Env)
+
+		return Decode([]byte(value))
+	default:
+		return readFrom(os.Stdin)
+	}
+}
+
+// Decode strictly decodes the base64 encoded PSK.
+// Only a single trailing newline is allowed after the PSK.
+func Decode(data []byte) ([]byte, error) {
+	data = bytes.TrimSuffix(data, []byte("\n"))
+	data = bytes.TrimSuffix(data, []byte("\r"))
+
+	if len(data) == 0 {
+		return nil, ErrEmptyPSK
+	}
+
+	if len(data) > maxEncodedLen || bytes.ContainsAny(data, " \t\r\n") {
+		return nil, ErrTrailingData
+	}
+
+	psk, err := base64.StdEncoding.Strict().DecodeString(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEncoding, err)
+	}
+
+	if len(psk) != snapCore.MinPSKSize && len(psk) != snapCore.PSKSize {
+		return nil, fmt.Errorf("%w: %d bytes, want %d or %d", ErrInvalidSize, len(psk), snapCore.MinPSKSize, snapCore.PSKSize)
+	}
+
+	return psk, nil
+}
+
+// ExitCode returns the process exit code for the PSK error.
+func ExitCode(err error) int {
+	switch {
+	case errors.Is(err, ErrEmptyPSK):
+		return ExitCodeEmptyPSK
+	case errors.Is(err, ErrInvalidEncoding):
+		return ExitCodeInvalidEncoding
+	case errors.Is(err, ErrInvalidSize):
+		return ExitCodeInvalidSize
+	case errors.Is(err, ErrTrailingData):
+		return ExitCodeTrailingData
+	case errors.Is(err, ErrMultipleSources):
+		return ExitCodeMultipleSources
+	case errors.Is(err, ErrSourceNotFound):
+		return ExitCodeSourceNotFound
+	case errors.Is(err, ErrInsecurePermissions):
+		return ExitCodeInsecurePermissions
+	default:
+		return ExitCodeOther
+	}
+}
+
+func readFrom(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxReadLen))
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+
+	if len(data) == maxReadLen {
+		return nil, ErrTrailingData
+	}
+
+	return Decode(data)
+}
+
+func readFile(filename string) ([]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %w", ErrSourceNotFound, err)
+		}
+
+		return nil, fmt.Errorf("open: %w", err)
+	}
+
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("%w: not a regular file", ErrInsecurePermissions)
+	}
+
+	if fi.Mode().Perm()&insecurePermMask != 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInsecurePermissions, fi.Mode().Perm())
+	}
+
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Geteuid() {
+		return nil, fmt.Errorf("%w: owner uid %d", ErrInsecurePermissions, st.Uid)
+	}
+
+	return readFrom(f)
+}
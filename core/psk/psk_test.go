@@ -0,0 +1,120 @@
+package psk
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Decode(t *testing.T) {
+	psk16 := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	psk32 := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+	tests := []struct {
+		name    string
+		data    string
+		wantLen int
+		wantErr error
+	}{
+		{name: "empty", data: "", wantErr: ErrEmptyPSK},
+		{name: "newline only", data: "\n", wantErr: ErrEmptyPSK},
+		{name: "16 bytes", data: psk16, wantLen: 16},
+		{name: "32 bytes", data: psk32, wantLen: 32},
+		{name: "32 bytes with newline", data: psk32 + "\n", wantLen: 32},
+		{name: "32 bytes with crlf", data: psk32 + "\r\n", wantLen: 32},
+		{name: "two newlines", data: psk32 + "\n\n", wantErr: ErrTrailingData},
+		{name: "trailing garbage", data: psk32 + "AAAA", wantErr: ErrTrailingData},
+		{name: "trailing space", data: psk16 + " ", wantErr: ErrTrailingData},
+		{name: "short", data: base64.StdEncoding.EncodeToString([]byte("short")), wantErr: ErrInvalidSize},
+		{name: "24 bytes", data: base64.StdEncoding.EncodeToString([]byte(strings.Repeat("x", 24))), wantErr: ErrInvalidSize},
+		{name: "not base64", data: "!!!!", wantErr: ErrInvalidEncoding},
+		{name: "no padding", data: strings.TrimRight(psk16, "="), wantErr: ErrInvalidEncoding},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			psk, err := Decode([]byte(tt.data))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if len(psk) != tt.wantLen {
+				t.Errorf("Decode() len = %d, want %d", len(psk), tt.wantLen)
+			}
+		})
+	}
+}
+
+func Test_Read(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef")) + "\n"
+	dir := t.TempDir()
+
+	secure := filepath.Join(dir, "secure")
+	if err := os.WriteFile(secure, []byte(encoded), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	insecure := filepath.Join(dir, "insecure")
+	if err := os.WriteFile(insecure, []byte(encoded), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chmod(insecure, 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TEST_SNAP_PSK", strings.TrimSpace(encoded))
+
+	tests := []struct {
+		name    string
+		src     Source
+		wantErr error
+	}{
+		{name: "secure file", src: Source{File: secure}},
+		{name: "insecure file", src: Source{File: insecure}, wantErr: ErrInsecurePermissions},
+		{name: "missing file", src: Source{File: filepath.Join(dir, "missing")}, wantErr: ErrSourceNotFound},
+		{name: "env", src: Source{Env: "TEST_SNAP_PSK"}},
+		{name: "env consumed", src: Source{Env: "TEST_SNAP_PSK"}, wantErr: ErrSourceNotFound},
+		{name: "multiple", src: Source{File: secure, Env: "TEST_SNAP_PSK"}, wantErr: ErrMultipleSources},
+		{name: "closed fd", src: Source{FD: closedFD(t)}, wantErr: ErrSourceNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Read(tt.src)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Read() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// closedFD returns the number of the file descriptor closed just now.
+func closedFD(t *testing.T) int {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fd := int(r.Fd())
+
+	r.Close()
+	w.Close()
+
+	return fd
+}
+
+func Test_ExitCode(t *testing.T) {
+	if code := ExitCode(errors.Join(errors.New("read psk"), ErrInvalidSize)); code != ExitCodeInvalidSize {
+		t.Errorf("ExitCode() = %d, want %d", code, ExitCodeInvalidSize)
+	}
+
+	if code := ExitCode(errors.New("other")); code != ExitCodeOther {
+		t.Errorf("ExitCode() = %d, want %d", code, ExitCodeOther)
+	}
+}
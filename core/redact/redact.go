@@ -0,0 +1,233 @@
+// Package redact drops or hashes the privacy sensitive
+// brigade fields which are not needed for the recovery.
+package redact
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Wildcard matches every array element or object member in the path.
+const Wildcard = "*"
+
+// HashPrefix is a prefix of the hashed values.
+const HashPrefix = "hmac-sha256:"
+
+var (
+	ErrInvalidPath   = errors.New("invalid path")
+	ErrEmptyPolicy   = errors.New("empty policy")
+	ErrEmptyKey      = errors.New("empty redaction key")
+	ErrTrailingValue = errors.New("trailing data after json value")
+)
+
+// Policy is a declarative redaction policy. The paths are JSON pointers
+// (RFC 6901) where the "*" token matches any array element or object member.
+// The paths missing in the document are ignored.
+type Policy struct {
+	// Drop is a list of the paths to remove.
+	Drop []string `json:"drop,omitempty"`
+	// Hash is a list of the paths to replace with
+	// the keyed hash of the canonical JSON value.
+	Hash []string `json:"hash,omitempty"`
+}
+
+// ParsePolicy parses and checks the policy.
+func ParsePolicy(data []byte) (*Policy, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	p := &Policy{}
+	if err := dec.Decode(p); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+
+	if len(p.Drop) == 0 && len(p.Hash) == 0 {
+		return nil, ErrEmptyPolicy
+	}
+
+	for _, path := range slices.Concat(p.Drop, p.Hash) {
+		if _, err := parsePath(path); err != nil {
+			return nil, err
+		}
+	}
+
+	slices.Sort(p.Drop)
+	p.Drop = slices.Compact(p.Drop)
+	slices.Sort(p.Hash)
+	p.Hash = slices.Compact(p.Hash)
+
+	return p, nil
+}
+
+// Digest returns the base64 SHA-256 digest of the normalized policy,
+// so the same rules give the same hash regardless of the file formatting.
+func (p *Policy) Digest() (string, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", fmt.Errorf("marshal: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+
+	return base64.StdEncoding.EncodeToString(sum[:]), nil
+}
+
+// Apply returns the redacted JSON document. The hashed values are replaced
+// with the HashPrefix and the base64 HMAC-SHA256 of the value with the key,
+// so they can be compared between snapshots but can't be brute forced
+// without the key.
+func (p *Policy) Apply(data []byte, key []byte) ([]byte, error) {
+	if len(p.Hash) > 0 && len(key) == 0 {
+		return nil, ErrEmptyKey
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+
+	if dec.More() {
+		return nil, ErrTrailingValue
+	}
+
+	for _, path := range p.Drop {
+		tokens, err := parsePath(path)
+		if err != nil {
+			return nil, err
+		}
+
+		doc = drop(doc, tokens)
+	}
+
+	for _, path := range p.Hash {
+		tokens, err := parsePath(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if doc, err = hash(doc, tokens, key); err != nil {
+			return nil, fmt.Errorf("hash %s: %w", path, err)
+		}
+	}
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+
+	return out, nil
+}
+
+// drop removes the matched values, the root can't be dropped.
+func drop(node any, tokens []string) any {
+	if len(tokens) == 0 {
+		return node
+	}
+
+	last := len(tokens) == 1
+
+	switch n := node.(type) {
+	case map[string]any:
+		for k, v := range n {
+			if tokens[0] != Wildcard && tokens[0] != k {
+				continue
+			}
+
+			if last {
+				delete(n, k)
+
+				continue
+			}
+
+			n[k] = drop(v, tokens[1:])
+		}
+
+		return n
+	case []any:
+		if last && tokens[0] == Wildcard {
+			return []any{}
+		}
+
+		for i, v := range n {
+			if tokens[0] != Wildcard && tokens[0] != fmt.Sprint(i) {
+				continue
+			}
+
+			if last {
+				return slices.Delete(n, i, i+1)
+			}
+
+			n[i] = drop(v, tokens[1:])
+		}
+
+		return n
+	default:
+		return node
+	}
+}
+
+// hash replaces the matched values with their keyed hashes.
+func hash(node any, tokens []string, key []byte) (any, error) {
+	if len(tokens) == 0 {
+		data, err := json.Marshal(node)
+		if err != nil {
+			return nil, fmt.Errorf("marshal: %w", err)
+		}
+
+		mac := hmac.New(sha256.New, key)
+		mac.Write(data)
+
+		return HashPrefix + base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+	}
+
+	var err error
+
+	switch n := node.(type) {
+	case map[string]any:
+		for k, v := range n {
+			if tokens[0] != Wildcard && tokens[0] != k {
+				continue
+			}
+
+			if n[k], err = hash(v, tokens[1:], key); err != nil {
+				return nil, err
+			}
+		}
+	case []any:
+		for i, v := range n {
+			if tokens[0] != Wildcard && tokens[0] != fmt.Sprint(i) {
+				continue
+			}
+
+			if n[i], err = hash(v, tokens[1:], key); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return node, nil
+}
+
+// parsePath splits the JSON pointer into the unescaped tokens.
+func parsePath(path string) ([]string, error) {
+	if path == "" || path[0] != '/' {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+	}
+
+	tokens := strings.Split(path[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,133 @@
+package redact
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testBrigade = `{
+ "brigade_id": "AAAAAAAAAAAAAAAAAAAAAAAAAA",
+ "counters_stack": [1, 2],
+ "endpoints": {"192.0.2.1": "2024-01-01T00:00:00Z"},
+ "users": [
+  {"user_id": "u1", "ipv4_addr": "100.64.0.2", "quotas": {"rx": 12345678901234567890}},
+  {"user_id": "u2", "ipv4_addr": "100.64.0.3", "quotas": {"rx": 2}}
+ ]
+}`
+
+func Test_Policy_Apply(t *testing.T) {
+	key := []byte("0123456789abcdef")
+
+	tests := []struct {
+		name   string
+		policy string
+		want   string
+	}{
+		{
+			name:   "drop",
+			policy: `{"drop": ["/counters_stack", "/users/*/quotas", "/missing/path"]}`,
+			want:   `{"brigade_id":"AAAAAAAAAAAAAAAAAAAAAAAAAA","endpoints":{"192.0.2.1":"2024-01-01T00:00:00Z"},"users":[{"ipv4_addr":"100.64.0.2","user_id":"u1"},{"ipv4_addr":"100.64.0.3","user_id":"u2"}]}`,
+		},
+		{
+			name:   "drop array element",
+			policy: `{"drop": ["/users/0", "/endpoints/*", "/counters_stack/*"]}`,
+			want:   `{"brigade_id":"AAAAAAAAAAAAAAAAAAAAAAAAAA","counters_stack":[],"endpoints":{},"users":[{"ipv4_addr":"100.64.0.3","quotas":{"rx":2},"user_id":"u2"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParsePolicy([]byte(tt.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := p.Apply([]byte(testBrigade), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("Apply() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Policy_Hash(t *testing.T) {
+	p, err := ParsePolicy([]byte(`{"hash": ["/users/*/ipv4_addr", "/endpoints"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := p.Apply([]byte(testBrigade), []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(got), "100.64.0.") || strings.Contains(string(got), "192.0.2.1") {
+		t.Errorf("Apply() = %s, the values are not hashed", got)
+	}
+
+	if n := strings.Count(string(got), HashPrefix); n != 3 {
+		t.Errorf("Apply() = %s, %d hashed values, want 3", got, n)
+	}
+
+	again, err := p.Apply([]byte(testBrigade), []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := p.Apply([]byte(testBrigade), []byte("fedcba9876543210"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != string(again) || string(got) == string(other) {
+		t.Error("Apply() hashes are not keyed or not deterministic")
+	}
+
+	if _, err := p.Apply([]byte(testBrigade), nil); !errors.Is(err, ErrEmptyKey) {
+		t.Errorf("Apply() error = %v, want %v", err, ErrEmptyKey)
+	}
+}
+
+func Test_ParsePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr error
+	}{
+		{name: "empty", policy: `{}`, wantErr: ErrEmptyPolicy},
+		{name: "relative path", policy: `{"drop": ["users"]}`, wantErr: ErrInvalidPath},
+		{name: "root", policy: `{"hash": [""]}`, wantErr: ErrInvalidPath},
+		{name: "unknown field", policy: `{"keep": ["/users"]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePolicy([]byte(tt.policy))
+			if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Errorf("ParsePolicy() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	a, err := ParsePolicy([]byte(`{"drop": ["/b", "/a", "/a"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ParsePolicy([]byte(`{ "drop": ["/a", "/b"] }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	da, _ := a.Digest()
+	db, _ := b.Digest()
+
+	if da != db {
+		t.Errorf("Digest() = %s and %s for the same rules", da, db)
+	}
+}
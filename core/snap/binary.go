@@ -0,0 +1,270 @@
+package snap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+)
+
+// Binary envelope layout:
+//
+//	magic:   "VGSNAPB" + [1]byte(layout version)
+//	header:  [4]byte(big endian length) + compact envelope JSON without the payload
+//	payload: raw ciphertext as the frame chunks, see frameWriter,
+//	         no chunks if the payload is detached
+//	trailer: [4]byte(big endian length) + compact JSON of the trailer fields
+//
+// The payload is not base64 encoded, so the envelope is about
+// a third smaller than the JSON one.
+
+const (
+	binaryMagic = "VGSNAPB\x01"
+
+	// MaxBinaryHeaderSize is a maximum size of the binary envelope header and trailer.
+	MaxBinaryHeaderSize = 1024 * 1024
+)
+
+var (
+	ErrUnknownEncoding = errors.New("unknown envelope encoding")
+	ErrInvalidBinary   = errors.New("invalid binary envelope")
+)
+
+// envelopeEncoders are the streaming envelope writers by encoding.
+var envelopeEncoders = map[string]func(
+	w io.Writer,
+	e *snapCore.EncryptedBrigade,
+	payload func(w io.Writer) error,
+	trailer func() (any, error),
+) error{
+	snapCore.EncodingJSON:   writeEnvelope,
+	snapCore.EncodingBinary: writeBinaryEnvelope,
+}
+
+// IsBinaryEnvelope reports whether the data starts as the binary envelope.
+func IsBinaryEnvelope(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(binaryMagic))
+}
+
+// EncodeEnvelope writes the decoded envelope in the encoding.
+// It is used to convert the envelopes between the encodings.
+func EncodeEnvelope(w io.Writer, e *snapCore.EncryptedBrigade, encoding string) error {
+	encode, ok := envelopeEncoders[encodingName(encoding)]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownEncoding, encoding)
+	}
+
+	head := *e
+	head.Payload = ""
+	head.DigestHMAC = ""
+	head.Meta = nil
+	head.HeaderHMAC = ""
+
+	payload := func(pw io.Writer) error {
+		if _, err := io.Copy(pw, base64.NewDecoder(base64.StdEncoding, strings.NewReader(e.Payload))); err != nil {
+			return fmt.Errorf("decode payload: %w", err)
+		}
+
+		return nil
+	}
+
+	if e.PayloadSHA256 != "" {
+		payload = nil
+	}
+
+	trailer := func() (any, error) {
+		if e.DigestHMAC == "" && e.Meta == nil && e.HeaderHMAC == "" {
+			return nil, nil
+		}
+
+		return &envelopeTrailer{DigestHMAC: e.DigestHMAC, Meta: e.Meta, HeaderHMAC: e.HeaderHMAC}, nil
+	}
+
+	return encode(w, &head, payload, trailer)
+}
+
+// DecodeBinaryEnvelope decodes the binary envelope with the decoder
+// of its format version, see DecodeEnvelope.
+func DecodeBinaryEnvelope(r io.Reader) (*snapCore.EncryptedBrigade, error) {
+	payload := &strings.Builder{}
+	enc := base64.NewEncoder(base64.StdEncoding, payload)
+
+	fields, err := readBinaryEnvelope(r, enc)
+	if err != nil {
+		return nil, err
+	}
+
+	enc.Close()
+
+	if payload.Len() > 0 {
+		if _, ok := fields["payload"]; ok {
+			return nil, fmt.Errorf("%w: duplicate field payload", ErrInvalidBinary)
+		}
+
+		fields["payload"], err = json.Marshal(payload.String())
+		if err != nil {
+			return nil, fmt.Errorf("marshal payload: %w", err)
+		}
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+
+	return DecodeEnvelope(data)
+}
+
+// readBinaryEnvelope reads the header and the trailer fields
+// of the binary envelope, the payload is copied to the writer.
+func readBinaryEnvelope(r io.Reader, payload io.Writer) (map[string]json.RawMessage, error) {
+	magic := make([]byte, len(binaryMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != binaryMagic {
+		return nil, fmt.Errorf("%w: magic", ErrInvalidBinary)
+	}
+
+	fields := map[string]json.RawMessage{}
+
+	if err := readBinaryFields(r, fields); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+
+	if _, err := io.Copy(payload, newFrameReader(r)); err != nil {
+		return nil, fmt.Errorf("%w: payload: %w", ErrInvalidBinary, err)
+	}
+
+	if err := readBinaryFields(r, fields); err != nil {
+		return nil, fmt.Errorf("trailer: %w", err)
+	}
+
+	if n, _ := io.Copy(io.Discard, r); n != 0 {
+		return nil, fmt.Errorf("%w: trailing data: %d bytes", ErrInvalidBinary, n)
+	}
+
+	return fields, nil
+}
+
+// writeBinaryEnvelope is the binary counterpart of writeEnvelope.
+func writeBinaryEnvelope(
+	w io.Writer,
+	e *snapCore.EncryptedBrigade,
+	payload func(w io.Writer) error,
+	trailer func() (any, error),
+) error {
+	e.Payload = ""
+
+	bw := bufio.NewWriterSize(w, StreamBufferSize)
+	bw.WriteString(binaryMagic)
+
+	if err := writeBinaryFields(bw, e); err != nil {
+		return fmt.Errorf("header: %w", err)
+	}
+
+	fw := newFrameWriter(bw)
+
+	// the frame writer writes the chunk header and data separately,
+	// buffer the payload to keep the chunks large
+	pw := bufio.NewWriterSize(fw, StreamBufferSize)
+
+	if payload != nil {
+		if err := payload(pw); err != nil {
+			return fmt.Errorf("payload: %w", err)
+		}
+	}
+
+	if err := pw.Flush(); err != nil {
+		return fmt.Errorf("payload: %w", err)
+	}
+
+	if err := fw.Close(nil); err != nil {
+		return fmt.Errorf("payload: %w", err)
+	}
+
+	tail, err := trailer()
+	if err != nil {
+		return fmt.Errorf("trailer: %w", err)
+	}
+
+	if tail == nil {
+		tail = struct{}{}
+	}
+
+	if err := writeBinaryFields(bw, tail); err != nil {
+		return fmt.Errorf("trailer: %w", err)
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+
+	return nil
+}
+
+func writeBinaryFields(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	if len(data) > MaxBinaryHeaderSize || len(data) > math.MaxUint32 {
+		return fmt.Errorf("%w: too big: %d bytes", ErrInvalidBinary, len(data))
+	}
+
+	hdr := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+
+	if _, err := w.Write(append(hdr, data...)); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	return nil
+}
+
+// readBinaryFields reads the length prefixed JSON object into the fields.
+func readBinaryFields(r io.Reader, fields map[string]json.RawMessage) error {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return fmt.Errorf("%w: length: %w", ErrInvalidBinary, err)
+	}
+
+	size := binary.BigEndian.Uint32(hdr[:])
+	if size > MaxBinaryHeaderSize {
+		return fmt.Errorf("%w: too big: %d bytes", ErrInvalidBinary, size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBinary, err)
+	}
+
+	part := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &part); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBinary, err)
+	}
+
+	for k, v := range part {
+		if _, ok := fields[k]; ok {
+			return fmt.Errorf("%w: duplicate field %s", ErrInvalidBinary, k)
+		}
+
+		fields[k] = v
+	}
+
+	return nil
+}
+
+// encodingName returns the encoding name, empty means JSON.
+func encodingName(encoding string) string {
+	if encoding == "" {
+		return snapCore.EncodingJSON
+	}
+
+	return encoding
+}
@@ -0,0 +1,95 @@
+package snap
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+)
+
+func Test_BinaryEnvelope(t *testing.T) {
+	keys := genTestKeys(t)
+	psk := []byte("0123456789abcdef0123456789abcdef")
+	data := strings.Repeat(`{"brigade_id":"brigade1","version":12}`, StreamBufferSize/16)
+
+	opts := keys.snapOpts(t, psk)
+	opts.Encoding = snapCore.EncodingBinary
+	opts.DigestHMAC = true
+
+	bin, err := MakeSnapshot(strings.NewReader(data), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsBinaryEnvelope(bin) {
+		t.Fatal("IsBinaryEnvelope() = false")
+	}
+
+	e, err := DecodeBinaryEnvelope(bytes.NewReader(bin))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.DigestHMAC == "" || e.Version != snapCore.EnvelopeVersion {
+		t.Errorf("DecodeBinaryEnvelope() = %+v, want the trailer and the version", e)
+	}
+
+	ropts := keys.restoreOpts(t, e)
+	ropts.PSK = psk
+
+	got, err := OpenSnapshot(e, ropts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != data {
+		t.Errorf("OpenSnapshot() len = %d, want %d", len(got), len(data))
+	}
+
+	// binary -> json -> binary is lossless
+	js := &bytes.Buffer{}
+	if err := EncodeEnvelope(js, e, snapCore.EncodingJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	if js.Len() <= len(bin) {
+		t.Errorf("EncodeEnvelope() json len = %d, binary len = %d, want json bigger", js.Len(), len(bin))
+	}
+
+	e2, err := DecodeEnvelope(js.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again := &bytes.Buffer{}
+	if err := EncodeEnvelope(again, e2, snapCore.EncodingBinary); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(again.Bytes(), bin) {
+		t.Error("EncodeEnvelope() binary -> json -> binary is not lossless")
+	}
+
+	invalid := []struct {
+		name string
+		data []byte
+	}{
+		{name: "magic", data: append([]byte("VGSNAPB\x02"), bin[len(binaryMagic):]...)},
+		{name: "truncated", data: bin[:len(bin)-10]},
+		{name: "trailing data", data: append(bytes.Clone(bin), 0)},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeBinaryEnvelope(bytes.NewReader(tt.data)); !errors.Is(err, ErrInvalidBinary) {
+				t.Errorf("DecodeBinaryEnvelope() error = %v, want %v", err, ErrInvalidBinary)
+			}
+		})
+	}
+
+	if err := EncodeEnvelope(&bytes.Buffer{}, e, "cbor"); !errors.Is(err, ErrUnknownEncoding) {
+		t.Errorf("EncodeEnvelope() error = %v, want %v", err, ErrUnknownEncoding)
+	}
+}
@@ -0,0 +1,93 @@
+package snap
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	snapCore "github.com/vpngen/keydesk-snap/core"
+)
+
+var (
+	ErrUnknownCompression = fmt.Errorf("unknown compression")
+	ErrCompressionLevel   = fmt.Errorf("invalid compression level")
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// compressionAlgorithm returns the envelope compression,
+// empty means gzip for compatibility with the old snapshots.
+func compressionAlgorithm(alg string) string {
+	if alg == "" {
+		return snapCore.CompressionGzip
+	}
+
+	return alg
+}
+
+// newCompressor returns the compressing writer.
+// Zero level means the algorithm default level.
+func newCompressor(w io.Writer, alg string, level int) (io.WriteCloser, error) {
+	switch compressionAlgorithm(alg) {
+	case snapCore.CompressionNone:
+		return nopWriteCloser{w}, nil
+	case snapCore.CompressionGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+
+		wz, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, fmt.Errorf("%w: gzip: %w", ErrCompressionLevel, err)
+		}
+
+		return wz, nil
+	case snapCore.CompressionZstd:
+		encLevel := zstd.SpeedDefault
+		if level != 0 {
+			if level < 1 || level > 22 {
+				return nil, fmt.Errorf("%w: zstd: %d", ErrCompressionLevel, level)
+			}
+
+			encLevel = zstd.EncoderLevelFromZstd(level)
+		}
+
+		wz, err := zstd.NewWriter(w, zstd.WithEncoderLevel(encLevel), zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+
+		return wz, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCompression, alg)
+	}
+}
+
+// newDecompressor returns the decompressing reader.
+func newDecompressor(r io.Reader, alg string) (io.ReadCloser, error) {
+	switch compressionAlgorithm(alg) {
+	case snapCore.CompressionNone:
+		return io.NopCloser(r), nil
+	case snapCore.CompressionGzip:
+		rz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+
+		return rz, nil
+	case snapCore.CompressionZstd:
+		rz, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+
+		return rz.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCompression, alg)
+	}
+}
@@ -0,0 +1,62 @@
+package snap
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+)
+
+func Test_CompressEncryptSnapshotTo_Algorithms(t *testing.T) {
+	data := strings.Repeat(`{"name":"Lorem ipsum dolor sit amet","ipv4_addr":"100.64.0.1"},`, 500)
+	secret := []byte("my super secret password")
+
+	tests := []struct {
+		name    string
+		alg     string
+		level   int
+		wantErr error
+	}{
+		{name: "default", alg: ""},
+		{name: "none", alg: snapCore.CompressionNone},
+		{name: "gzip", alg: snapCore.CompressionGzip},
+		{name: "gzip best", alg: snapCore.CompressionGzip, level: 9},
+		{name: "gzip invalid level", alg: snapCore.CompressionGzip, level: 42, wantErr: ErrCompressionLevel},
+		{name: "zstd", alg: snapCore.CompressionZstd},
+		{name: "zstd best", alg: snapCore.CompressionZstd, level: 19},
+		{name: "zstd invalid level", alg: snapCore.CompressionZstd, level: 23, wantErr: ErrCompressionLevel},
+		{name: "unknown", alg: "lzma", wantErr: ErrUnknownCompression},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted := &bytes.Buffer{}
+
+			opts := PayloadOpts{Compression: tt.alg, CompressionLevel: tt.level}
+
+			_, err := CompressEncryptSnapshotTo(encrypted, strings.NewReader(data), secret, opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CompressEncryptSnapshotTo() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if tt.alg != snapCore.CompressionNone && encrypted.Len() >= len(data) {
+				t.Errorf("CompressEncryptSnapshotTo() size = %d, not compressed %d", encrypted.Len(), len(data))
+			}
+
+			decrypted := &bytes.Buffer{}
+			if _, err := DecryptDecompressSnapshotTo(decrypted, encrypted, secret, opts); err != nil {
+				t.Fatal(err)
+			}
+
+			if decrypted.String() != data {
+				t.Errorf("DecryptDecompressSnapshotTo() len = %d, want %d", decrypted.Len(), len(data))
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package snap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+)
+
+var (
+	ErrNotDetached      = errors.New("payload is not detached")
+	ErrPayloadMismatch  = errors.New("detached payload mismatch")
+	ErrAlreadyDetached  = errors.New("payload is already detached")
+	ErrEmptyPayloadDir  = errors.New("empty detached payload dir")
+	ErrInvalidPayloadFP = errors.New("invalid detached payload sha256")
+)
+
+// checkPayloadRef checks the envelope has either the inline
+// or the well-formed detached payload reference.
+func checkPayloadRef(e *snapCore.EncryptedBrigade) error {
+	switch {
+	case e.PayloadSHA256 == "" && e.Payload == "":
+		return fmt.Errorf("%w: empty payload", ErrInvalidEnvelope)
+	case e.PayloadSHA256 == "" && e.PayloadSize != 0:
+		return fmt.Errorf("%w: payload_size without payload_sha256", ErrInvalidEnvelope)
+	case e.PayloadSHA256 == "":
+		return nil
+	case e.Payload != "":
+		return fmt.Errorf("%w: both payload and payload_sha256", ErrInvalidEnvelope)
+	case e.PayloadSize <= 0:
+		return fmt.Errorf("%w: payload_size %d", ErrInvalidEnvelope, e.PayloadSize)
+	}
+
+	if err := checkPayloadSHA256(e.PayloadSHA256); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidEnvelope, err)
+	}
+
+	return nil
+}
+
+// checkPayloadSHA256 checks the payload file name, so it can't escape the dir.
+func checkPayloadSHA256(fp string) error {
+	if len(fp) != 2*sha256.Size || strings.ToLower(fp) != fp {
+		return fmt.Errorf("%w: %q", ErrInvalidPayloadFP, fp)
+	}
+
+	if _, err := hex.DecodeString(fp); err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidPayloadFP, fp)
+	}
+
+	return nil
+}
+
+// payloadFile is a content addressed payload file being written.
+type payloadFile struct {
+	dir string
+	f   *os.File
+	sum *payloadSum
+}
+
+func createPayloadFile(dir string) (*payloadFile, error) {
+	if dir == "" {
+		return nil, ErrEmptyPayloadDir
+	}
+
+	f, err := os.CreateTemp(dir, ".payload-")
+	if err != nil {
+		return nil, fmt.Errorf("create temp: %w", err)
+	}
+
+	return &payloadFile{dir: dir, f: f, sum: newPayloadSum()}, nil
+}
+
+func (pf *payloadFile) Write(p []byte) (int, error) {
+	n, err := pf.f.Write(p)
+	pf.sum.Write(p[:n])
+
+	return n, err
+}
+
+// Commit renames the file to its SHA-256 and returns the reference.
+// The existing file with the same name has the same content,
+// so it is just replaced.
+func (pf *payloadFile) Commit() (string, int64, error) {
+	defer os.Remove(pf.f.Name())
+
+	if err := pf.f.Close(); err != nil {
+		return "", 0, fmt.Errorf("close: %w", err)
+	}
+
+	fp, size := pf.sum.Sum()
+
+	if err := os.Rename(pf.f.Name(), filepath.Join(pf.dir, fp)); err != nil {
+		return "", 0, fmt.Errorf("rename: %w", err)
+	}
+
+	return fp, size, nil
+}
+
+// Abort removes the unfinished file.
+func (pf *payloadFile) Abort() {
+	pf.f.Close()
+	os.Remove(pf.f.Name())
+}
+
+// writeDetached writes the payload to the content addressed
+// file in the dir and puts the reference into the envelope.
+func writeDetached(e *snapCore.EncryptedBrigade, dir string, payload func(w io.Writer) error) error {
+	pf, err := createPayloadFile(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := payload(pf); err != nil {
+		pf.Abort()
+
+		return err
+	}
+
+	e.PayloadSHA256, e.PayloadSize, err = pf.Commit()
+	if err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
+	return nil
+}
+
+// DetachPayload writes the inline envelope payload to the content
+// addressed file in the dir and replaces the payload with the reference.
+func DetachPayload(e *snapCore.EncryptedBrigade, dir string) error {
+	if e.PayloadSHA256 != "" {
+		return ErrAlreadyDetached
+	}
+
+	if e.Version < snapCore.EnvelopeVersion2 {
+		return fmt.Errorf("%w: version %d", ErrUnknownEnvelopeVersion, e.Version)
+	}
+
+	err := writeDetached(e, dir, func(w io.Writer) error {
+		_, err := io.Copy(w, base64.NewDecoder(base64.StdEncoding, strings.NewReader(e.Payload)))
+
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("write payload: %w", err)
+	}
+
+	e.Payload = ""
+	e.Version = max(e.Version, snapCore.EnvelopeVersion3)
+
+	return nil
+}
+
+// AttachPayload reads and verifies the detached payload file
+// from the dir and puts it inline into the envelope.
+func AttachPayload(e *snapCore.EncryptedBrigade, dir string) error {
+	r, err := openDetachedPayload(e, dir)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	buf := &bytes.Buffer{}
+	if _, err := io.Copy(buf, r); err != nil {
+		return fmt.Errorf("read payload: %w", err)
+	}
+
+	e.Payload = base64.StdEncoding.EncodeToString(buf.Bytes())
+	e.PayloadSHA256, e.PayloadSize = "", 0
+
+	return nil
+}
+
+// detachedPayload verifies the size and the SHA-256
+// of the payload file when it is read to the end.
+type detachedPayload struct {
+	e   *snapCore.EncryptedBrigade
+	f   *os.File
+	sum *payloadSum
+}
+
+// openDetachedPayload opens the payload file referenced by the envelope.
+// The mismatch is reported by the read of the end of the file.
+func openDetachedPayload(e *snapCore.EncryptedBrigade, dir string) (io.ReadCloser, error) {
+	if e.PayloadSHA256 == "" {
+		return nil, ErrNotDetached
+	}
+
+	if err := checkPayloadSHA256(e.PayloadSHA256); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(dir, e.PayloadSHA256))
+	if err != nil {
+		return nil, fmt.Errorf("open payload: %w", err)
+	}
+
+	return &detachedPayload{e: e, f: f, sum: newPayloadSum()}, nil
+}
+
+func (p *detachedPayload) Read(b []byte) (int, error) {
+	n, err := p.f.Read(b)
+	p.sum.Write(b[:n])
+
+	if p.sum.n > p.e.PayloadSize {
+		return n, fmt.Errorf("%w: size > %d", ErrPayloadMismatch, p.e.PayloadSize)
+	}
+
+	if errors.Is(err, io.EOF) {
+		fp, size := p.sum.Sum()
+		if size != p.e.PayloadSize {
+			return n, fmt.Errorf("%w: size %d, want %d", ErrPayloadMismatch, size, p.e.PayloadSize)
+		}
+
+		if fp != p.e.PayloadSHA256 {
+			return n, fmt.Errorf("%w: sha256", ErrPayloadMismatch)
+		}
+	}
+
+	return n, err
+}
+
+func (p *detachedPayload) Close() error {
+	return p.f.Close()
+}
@@ -0,0 +1,159 @@
+package snap
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+)
+
+func Test_DetachedPayload(t *testing.T) {
+	keys := genTestKeys(t)
+	psk := []byte("0123456789abcdef0123456789abcdef")
+	data := `{"brigade_id":"brigade1","version":12}`
+
+	for _, encoding := range []string{snapCore.EncodingJSON, snapCore.EncodingBinary} {
+		t.Run(encoding, func(t *testing.T) {
+			dir := t.TempDir()
+
+			opts := keys.snapOpts(t, psk)
+			opts.Encoding = encoding
+			opts.DigestHMAC = true
+			opts.DetachedDir = dir
+
+			snap, err := MakeSnapshot(strings.NewReader(data), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var e *snapCore.EncryptedBrigade
+			if encoding == snapCore.EncodingBinary {
+				e, err = DecodeBinaryEnvelope(bytes.NewReader(snap))
+			} else {
+				e, err = DecodeEnvelope(snap)
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if e.Payload != "" || e.PayloadSHA256 == "" {
+				t.Fatalf("decoded payload = %q, sha256 = %q, want detached", e.Payload, e.PayloadSHA256)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(entries) != 1 || entries[0].Name() != e.PayloadSHA256 {
+				t.Fatalf("payload dir = %v, want the only %s", entries, e.PayloadSHA256)
+			}
+
+			ropts := keys.restoreOpts(t, e)
+			ropts.PSK = psk
+
+			if _, err := OpenSnapshot(e, ropts); !errors.Is(err, ErrEmptyPayloadDir) {
+				t.Errorf("OpenSnapshot() without dir error = %v, want %v", err, ErrEmptyPayloadDir)
+			}
+
+			ropts.PayloadDir = dir
+
+			got, err := OpenSnapshot(e, ropts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != data {
+				t.Errorf("OpenSnapshot() = %s, want %s", got, data)
+			}
+
+			// attach and detach again to the same content addressed file
+			attached := *e
+			if err := AttachPayload(&attached, dir); err != nil {
+				t.Fatal(err)
+			}
+
+			if attached.Payload == "" || attached.PayloadSHA256 != "" || attached.PayloadSize != 0 {
+				t.Fatalf("AttachPayload() = %+v, want inline", attached)
+			}
+
+			if err := DetachPayload(&attached, dir); err != nil {
+				t.Fatal(err)
+			}
+
+			if attached.PayloadSHA256 != e.PayloadSHA256 || attached.PayloadSize != e.PayloadSize {
+				t.Errorf("DetachPayload() = %s/%d, want %s/%d",
+					attached.PayloadSHA256, attached.PayloadSize, e.PayloadSHA256, e.PayloadSize)
+			}
+
+			if err := DetachPayload(&attached, dir); !errors.Is(err, ErrAlreadyDetached) {
+				t.Errorf("DetachPayload() error = %v, want %v", err, ErrAlreadyDetached)
+			}
+		})
+	}
+}
+
+func Test_DetachedPayload_Mismatch(t *testing.T) {
+	keys := genTestKeys(t)
+	psk := []byte("0123456789abcdef0123456789abcdef")
+	data := `{"brigade_id":"brigade1","version":12}`
+
+	dir := t.TempDir()
+
+	opts := keys.snapOpts(t, psk)
+	opts.DetachedDir = dir
+
+	snap, err := MakeSnapshot(strings.NewReader(data), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := DecodeEnvelope(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ropts := keys.restoreOpts(t, e)
+	ropts.PSK = psk
+	ropts.PayloadDir = dir
+
+	name := filepath.Join(dir, e.PayloadSHA256)
+
+	payload, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		payload []byte
+		want    error
+	}{
+		{name: "missing", want: fs.ErrNotExist},
+		{name: "truncated", payload: payload[:len(payload)-1], want: ErrPayloadMismatch},
+		{name: "appended", payload: append(bytes.Clone(payload), 0), want: ErrPayloadMismatch},
+		{name: "replaced", payload: append(bytes.Clone(payload[:len(payload)-1]), payload[len(payload)-1]^1), want: ErrPayloadMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(name)
+
+			if tt.payload != nil {
+				if err := os.WriteFile(name, tt.payload, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err := OpenSnapshot(e, ropts); !errors.Is(err, tt.want) {
+				t.Errorf("OpenSnapshot() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package snap
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+)
+
+// Decoder decodes and checks the envelope JSON of the format version.
+type Decoder func(data []byte) (*snapCore.EncryptedBrigade, error)
+
+var (
+	ErrUnknownEnvelopeVersion = errors.New("unknown envelope version")
+	ErrInvalidEnvelope        = errors.New("invalid envelope")
+	ErrDecoderRegistered      = errors.New("envelope decoder already registered")
+)
+
+//go:embed schema/envelope-v*.json
+var schemas embed.FS
+
+var decoders = map[int]Decoder{
+	snapCore.EnvelopeVersion1: decodeEnvelopeV1,
+	snapCore.EnvelopeVersion2: decodeEnvelopeSinceV2(snapCore.EnvelopeVersion2),
+	snapCore.EnvelopeVersion3: decodeEnvelopeSinceV2(snapCore.EnvelopeVersion3),
+	snapCore.EnvelopeVersion4: decodeEnvelopeSinceV2(snapCore.EnvelopeVersion4),
+}
+
+// envelopeFieldVersions are the versions the envelope fields appeared in.
+// The fields absent here are in the envelope since version 2.
+var envelopeFieldVersions = map[string]int{
+	"payload_sha256": snapCore.EnvelopeVersion3,
+	"payload_size":   snapCore.EnvelopeVersion3,
+	"meta":           snapCore.EnvelopeVersion4,
+	"header_hmac":    snapCore.EnvelopeVersion4,
+}
+
+// RegisterDecoder registers the envelope decoder of the format version.
+// It is not safe to call it concurrently with DecodeEnvelope.
+func RegisterDecoder(version int, d Decoder) error {
+	if _, ok := decoders[version]; ok {
+		return fmt.Errorf("%w: %d", ErrDecoderRegistered, version)
+	}
+
+	decoders[version] = d
+
+	return nil
+}
+
+// DecodeEnvelope decodes the envelope JSON with the decoder
+// of its format version. The returned envelope version is always set.
+func DecodeEnvelope(data []byte) (*snapCore.EncryptedBrigade, error) {
+	v := struct {
+		Version *int `json:"version"`
+	}{}
+
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEnvelope, err)
+	}
+
+	version := snapCore.EnvelopeVersion1
+	if v.Version != nil {
+		version = *v.Version
+	}
+
+	decode, ok := decoders[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownEnvelopeVersion, version)
+	}
+
+	e, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("version %d: %w", version, err)
+	}
+
+	e.Version = version
+
+	return e, nil
+}
+
+// Schema returns the published JSON Schema of the envelope format version.
+func Schema(version int) ([]byte, error) {
+	data, err := schemas.ReadFile(fmt.Sprintf("schema/envelope-v%d.json", version))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownEnvelopeVersion, version)
+	}
+
+	return data, nil
+}
+
+// envelopeV1 is the envelope layout before the version field.
+type envelopeV1 struct {
+	Version               int                          `json:"version,omitempty"`
+	Tag                   string                       `json:"tag"`
+	GlobalSnapAt          time.Time                    `json:"global_snap_at"`
+	BrigadeID             string                       `json:"brigade_id"`
+	Payload               string                       `json:"payload"`
+	LocalSnapAt           time.Time                    `json:"local_snap_at"`
+	RealmKeyFP            string                       `json:"realm_key_fp"`
+	AuthorityKeyFP        string                       `json:"authority_key_fp"`
+	EncryptedLockerSecret string                       `json:"encrypted_locker_secret"`
+	Secrets               snapCore.EncryptedSecretPair `json:"sss_keys"`
+}
+
+func decodeEnvelopeV1(data []byte) (*snapCore.EncryptedBrigade, error) {
+	v1 := &envelopeV1{}
+	if err := decodeStrict(data, v1); err != nil {
+		return nil, err
+	}
+
+	e := &snapCore.EncryptedBrigade{
+		Tag:                   v1.Tag,
+		GlobalSnapAt:          v1.GlobalSnapAt,
+		BrigadeID:             v1.BrigadeID,
+		Payload:               v1.Payload,
+		LocalSnapAt:           v1.LocalSnapAt,
+		RealmKeyFP:            v1.RealmKeyFP,
+		AuthorityKeyFP:        v1.AuthorityKeyFP,
+		EncryptedLockerSecret: v1.EncryptedLockerSecret,
+		Secrets:               v1.Secrets,
+	}
+
+	if err := checkEnvelope(e); err != nil {
+		return nil, err
+	}
+
+	if e.Payload == "" {
+		return nil, fmt.Errorf("%w: empty payload", ErrInvalidEnvelope)
+	}
+
+	return e, nil
+}
+
+// decodeEnvelopeSinceV2 returns the decoder of the version 2 and later
+// envelopes, which differ only by the set of the fields.
+func decodeEnvelopeSinceV2(version int) Decoder {
+	return func(data []byte) (*snapCore.EncryptedBrigade, error) {
+		fields := map[string]json.RawMessage{}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidEnvelope, err)
+		}
+
+		for name := range fields {
+			if since, ok := envelopeFieldVersions[name]; ok && since > version {
+				return nil, fmt.Errorf("%w: %s is not in version %d", ErrInvalidEnvelope, name, version)
+			}
+		}
+
+		e := &snapCore.EncryptedBrigade{}
+		if err := decodeStrict(data, e); err != nil {
+			return nil, err
+		}
+
+		if err := checkEnvelope(e); err != nil {
+			return nil, err
+		}
+
+		if e.PlaintextDigest == "" {
+			return nil, fmt.Errorf("%w: empty plaintext_digest", ErrInvalidEnvelope)
+		}
+
+		if err := checkPayloadRef(e); err != nil {
+			return nil, err
+		}
+
+		// the header_hmac authenticates the header and the meta
+		// for the realm, it must not be stripped
+		if version >= snapCore.EnvelopeVersion4 && e.HeaderHMAC == "" {
+			return nil, fmt.Errorf("%w: empty header_hmac", ErrInvalidEnvelope)
+		}
+
+		return e, nil
+	}
+}
+
+// decodeStrict decodes the JSON object rejecting the unknown fields.
+func decodeStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidEnvelope, err)
+	}
+
+	if dec.More() {
+		return fmt.Errorf("%w: trailing data", ErrInvalidEnvelope)
+	}
+
+	return nil
+}
+
+// checkEnvelope checks the fields required by all versions.
+func checkEnvelope(e *snapCore.EncryptedBrigade) error {
+	for _, f := range []struct {
+		name  string
+		empty bool
+	}{
+		{name: "tag", empty: e.Tag == ""},
+		{name: "brigade_id", empty: e.BrigadeID == ""},
+		{name: "global_snap_at", empty: e.GlobalSnapAt.IsZero()},
+		{name: "local_snap_at", empty: e.LocalSnapAt.IsZero()},
+		{name: "encrypted_locker_secret", empty: e.EncryptedLockerSecret == ""},
+		{name: "sss_keys", empty: len(e.Secrets) == 0},
+	} {
+		if f.empty {
+			return fmt.Errorf("%w: empty %s", ErrInvalidEnvelope, f.name)
+		}
+	}
+
+	return nil
+}
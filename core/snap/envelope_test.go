@@ -0,0 +1,234 @@
+package snap
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	snapCrypto "github.com/vpngen/keydesk-snap/core/crypto"
+)
+
+func Test_DecodeEnvelope(t *testing.T) {
+	keys := genTestKeys(t)
+	psk := []byte("0123456789abcdef0123456789abcdef")
+	data := `{"brigade_id":"brigade1","version":12}`
+
+	v4opts := keys.snapOpts(t, psk)
+	v4opts.Meta = func() (*snapCore.SnapshotMeta, error) {
+		return &snapCore.SnapshotMeta{Users: 1}, nil
+	}
+
+	v4, err := MakeSnapshot(strings.NewReader(data), v4opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the version 1 envelope as it was made before the version field
+	opts := keys.snapOpts(t, psk)
+
+	secrets, err := genSecrets(opts.Tag, opts.BrigadeID, opts.GlobalSnapAt, psk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := CompressEncryptSnapshot(strings.NewReader(data), secrets.FinalSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locker, err := snapCrypto.EncryptSecret(opts.RealmKey, secrets.LockerSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authSecrets, err := snapCrypto.EncryptSecretForAuthorities(opts.AuthKeys, secrets.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v1, err := json.MarshalIndent(&envelopeV1{
+		Tag:                   opts.Tag,
+		GlobalSnapAt:          opts.GlobalSnapAt,
+		BrigadeID:             opts.BrigadeID,
+		Payload:               base64.StdEncoding.EncodeToString(payload),
+		LocalSnapAt:           secrets.LocalSnapAt,
+		RealmKeyFP:            opts.RealFP,
+		EncryptedLockerSecret: base64.StdEncoding.EncodeToString(locker),
+		Secrets:               authSecrets,
+	}, "", " ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modify := func(data []byte, f func(m map[string]any)) string {
+		m := map[string]any{}
+		if err := json.Unmarshal(data, &m); err != nil {
+			t.Fatal(err)
+		}
+
+		f(m)
+
+		out, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(out)
+	}
+
+	// the older envelopes are the version 4 one without the newer fields
+	v3 := []byte(modify(v4, func(m map[string]any) {
+		m["version"] = snapCore.EnvelopeVersion3
+		delete(m, "meta")
+		delete(m, "header_hmac")
+	}))
+	v2 := []byte(modify(v3, func(m map[string]any) { m["version"] = snapCore.EnvelopeVersion2 }))
+
+	for _, tt := range []struct {
+		name    string
+		data    []byte
+		version int
+	}{
+		{name: "v1", data: v1, version: snapCore.EnvelopeVersion1},
+		{name: "v2", data: v2, version: snapCore.EnvelopeVersion2},
+		{name: "v3", data: v3, version: snapCore.EnvelopeVersion3},
+		{name: "v4", data: v4, version: snapCore.EnvelopeVersion4},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := DecodeEnvelope(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if e.Version != tt.version {
+				t.Errorf("DecodeEnvelope() version = %d, want %d", e.Version, tt.version)
+			}
+
+			ropts := keys.restoreOpts(t, e)
+			ropts.PSK = psk
+
+			got, err := OpenSnapshot(e, ropts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != data {
+				t.Errorf("OpenSnapshot() = %s, want %s", got, data)
+			}
+		})
+	}
+
+	invalid := []struct {
+		name string
+		data string
+		want error
+	}{
+		{name: "unknown version", data: modify(v2, func(m map[string]any) { m["version"] = 99 }), want: ErrUnknownEnvelopeVersion},
+		{name: "unknown field", data: modify(v2, func(m map[string]any) { m["extra"] = 1 }), want: ErrInvalidEnvelope},
+		{name: "v2 field in v1", data: modify(v1, func(m map[string]any) { m["compression"] = "zstd" }), want: ErrInvalidEnvelope},
+		{name: "empty payload", data: modify(v1, func(m map[string]any) { m["payload"] = "" }), want: ErrInvalidEnvelope},
+		{name: "v2 without digest", data: modify(v2, func(m map[string]any) { delete(m, "plaintext_digest") }), want: ErrInvalidEnvelope},
+		{name: "v3 field in v2", data: modify(v2, func(m map[string]any) { m["payload_size"] = 1 }), want: ErrInvalidEnvelope},
+		{name: "v3 without payload", data: modify(v3, func(m map[string]any) { delete(m, "payload") }), want: ErrInvalidEnvelope},
+		{name: "v3 inline and detached", data: modify(v3, func(m map[string]any) { m["payload_sha256"], m["payload_size"] = strings.Repeat("ab", 32), 1 }), want: ErrInvalidEnvelope},
+		{name: "v3 size without sha256", data: modify(v3, func(m map[string]any) { m["payload_size"] = 1 }), want: ErrInvalidEnvelope},
+		{name: "v3 path in sha256", data: modify(v3, func(m map[string]any) {
+			delete(m, "payload")
+			m["payload_sha256"], m["payload_size"] = "../"+strings.Repeat("ab", 30)+"a", 1
+		}), want: ErrInvalidEnvelope},
+		{name: "v3 upper case sha256", data: modify(v3, func(m map[string]any) {
+			delete(m, "payload")
+			m["payload_sha256"], m["payload_size"] = strings.Repeat("AB", 32), 1
+		}), want: ErrInvalidEnvelope},
+		{name: "v4 field in v3", data: modify(v3, func(m map[string]any) { m["header_hmac"] = "AA==" }), want: ErrInvalidEnvelope},
+		{name: "v4 meta without header hmac", data: modify(v4, func(m map[string]any) { delete(m, "header_hmac") }), want: ErrInvalidEnvelope},
+		{name: "v4 without header hmac", data: modify(v4, func(m map[string]any) {
+			delete(m, "meta")
+			delete(m, "header_hmac")
+		}), want: ErrInvalidEnvelope},
+		{name: "v4 unknown meta field", data: modify(v4, func(m map[string]any) { m["meta"].(map[string]any)["extra"] = 1 }), want: ErrInvalidEnvelope},
+		{name: "v3 zero size", data: modify(v3, func(m map[string]any) {
+			delete(m, "payload")
+			m["payload_sha256"] = strings.Repeat("ab", 32)
+		}), want: ErrInvalidEnvelope},
+		{name: "not an object", data: `[]`, want: ErrInvalidEnvelope},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeEnvelope([]byte(tt.data)); !errors.Is(err, tt.want) {
+				t.Errorf("DecodeEnvelope() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Schema(t *testing.T) {
+	for _, tt := range []struct {
+		version int
+		layout  any
+	}{
+		{version: snapCore.EnvelopeVersion1, layout: envelopeV1{}},
+		{version: snapCore.EnvelopeVersion2, layout: snapCore.EncryptedBrigade{}},
+		{version: snapCore.EnvelopeVersion3, layout: snapCore.EncryptedBrigade{}},
+		{version: snapCore.EnvelopeVersion4, layout: snapCore.EncryptedBrigade{}},
+	} {
+		data, err := Schema(tt.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		schema := struct {
+			Properties           map[string]json.RawMessage `json:"properties"`
+			Required             []string                   `json:"required"`
+			AdditionalProperties bool                       `json:"additionalProperties"`
+		}{AdditionalProperties: true}
+
+		if err := json.Unmarshal(data, &schema); err != nil {
+			t.Fatalf("v%d: %s", tt.version, err)
+		}
+
+		var fields []string
+
+		typ := reflect.TypeOf(tt.layout)
+		for i := range typ.NumField() {
+			name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+			if envelopeFieldVersions[name] > tt.version {
+				continue
+			}
+
+			fields = append(fields, name)
+		}
+
+		var props []string
+		for name := range schema.Properties {
+			props = append(props, name)
+		}
+
+		slices.Sort(fields)
+		slices.Sort(props)
+
+		if !slices.Equal(fields, props) {
+			t.Errorf("v%d: schema properties = %v, want %v", tt.version, props, fields)
+		}
+
+		for _, name := range schema.Required {
+			if _, ok := schema.Properties[name]; !ok {
+				t.Errorf("v%d: required %s is not a property", tt.version, name)
+			}
+		}
+
+		if schema.AdditionalProperties {
+			t.Errorf("v%d: schema allows additional properties", tt.version)
+		}
+	}
+
+	if _, err := Schema(99); !errors.Is(err, ErrUnknownEnvelopeVersion) {
+		t.Errorf("Schema() error = %v, want %v", err, ErrUnknownEnvelopeVersion)
+	}
+}
@@ -0,0 +1,155 @@
+package snap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Framed payload layout inside the encryption:
+//
+//	chunk*: [4]byte(big endian length) + data
+//	end:    [4]byte(0)
+//	trailer
+//	padding (optional, discarded)
+//
+// The framing lets to put the trailer after the compressed
+// stream of any compression algorithm.
+
+const frameHeaderSize = 4
+
+var ErrInvalidFrame = errors.New("invalid payload frame")
+
+// frameWriter writes the data as the length prefixed chunks.
+type frameWriter struct {
+	w   io.Writer
+	hdr [frameHeaderSize]byte
+	n   int64
+}
+
+func newFrameWriter(w io.Writer) *frameWriter {
+	return &frameWriter{w: w}
+}
+
+func (fw *frameWriter) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > math.MaxUint32 {
+			chunk = chunk[:math.MaxUint32]
+		}
+
+		binary.BigEndian.PutUint32(fw.hdr[:], uint32(len(chunk)))
+
+		if err := fw.write(fw.hdr[:]); err != nil {
+			return written, err
+		}
+
+		if err := fw.write(chunk); err != nil {
+			return written, err
+		}
+
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+
+	return written, nil
+}
+
+// Close writes the end of chunks and the trailer.
+func (fw *frameWriter) Close(trailer []byte) error {
+	binary.BigEndian.PutUint32(fw.hdr[:], 0)
+
+	if err := fw.write(fw.hdr[:]); err != nil {
+		return err
+	}
+
+	return fw.write(trailer)
+}
+
+// Size returns the number of bytes written to the underlying writer.
+func (fw *frameWriter) Size() int64 {
+	return fw.n
+}
+
+func (fw *frameWriter) write(p []byte) error {
+	n, err := fw.w.Write(p)
+	fw.n += int64(n)
+
+	if err != nil {
+		return fmt.Errorf("frame: %w", err)
+	}
+
+	return nil
+}
+
+// frameReader reads the chunks data until the end of chunks.
+type frameReader struct {
+	r    io.Reader
+	hdr  [frameHeaderSize]byte
+	left uint32
+	done bool
+}
+
+func newFrameReader(r io.Reader) *frameReader {
+	return &frameReader{r: r}
+}
+
+func (fr *frameReader) Read(p []byte) (int, error) {
+	if fr.done {
+		return 0, io.EOF
+	}
+
+	if fr.left == 0 {
+		if _, err := io.ReadFull(fr.r, fr.hdr[:]); err != nil {
+			return 0, fmt.Errorf("%w: chunk header: %w", ErrInvalidFrame, err)
+		}
+
+		fr.left = binary.BigEndian.Uint32(fr.hdr[:])
+		if fr.left == 0 {
+			fr.done = true
+
+			return 0, io.EOF
+		}
+	}
+
+	if uint64(len(p)) > uint64(fr.left) {
+		p = p[:fr.left]
+	}
+
+	n, err := fr.r.Read(p)
+	fr.left -= uint32(n)
+
+	if errors.Is(err, io.EOF) {
+		return n, fmt.Errorf("%w: chunk: %w", ErrInvalidFrame, io.ErrUnexpectedEOF)
+	}
+
+	return n, err
+}
+
+// Trailer checks that all the chunks are consumed, reads the trailer
+// and discards the rest of the stream.
+func (fr *frameReader) Trailer(size int) ([]byte, error) {
+	n, err := io.Copy(io.Discard, fr)
+	if err != nil {
+		return nil, fmt.Errorf("discard chunks: %w", err)
+	}
+
+	if n != 0 {
+		return nil, fmt.Errorf("%w: unread data: %d bytes", ErrInvalidFrame, n)
+	}
+
+	trailer := make([]byte, size)
+	if _, err := io.ReadFull(fr.r, trailer); err != nil {
+		return nil, fmt.Errorf("%w: trailer: %w", ErrInvalidFrame, err)
+	}
+
+	if _, err := io.Copy(io.Discard, fr.r); err != nil {
+		return nil, fmt.Errorf("discard: %w", err)
+	}
+
+	return trailer, nil
+}
@@ -0,0 +1,105 @@
+package snap
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	snapDelta "github.com/vpngen/keydesk-snap/core/delta"
+)
+
+// The canonical envelope is the compact JSON with the sorted keys
+// of the envelope fields, where the payload is always referred by
+// payload_sha256 and payload_size of the encrypted payload and
+// the version is omitted. So the encoding, the inline or the detached
+// payload and the envelope layout version don't change it.
+// The snapshot ID is the hex SHA-256 of the canonical envelope.
+
+// payloadSum is a SHA-256 and a size of the written data.
+type payloadSum struct {
+	h hash.Hash
+	n int64
+}
+
+func newPayloadSum() *payloadSum {
+	return &payloadSum{h: sha256.New()}
+}
+
+func (s *payloadSum) Write(p []byte) (int, error) {
+	s.h.Write(p)
+	s.n += int64(len(p))
+
+	return len(p), nil
+}
+
+// Sum returns the hex SHA-256 and the size.
+func (s *payloadSum) Sum() (string, int64) {
+	return hex.EncodeToString(s.h.Sum(nil)), s.n
+}
+
+// CanonicalEnvelope returns the canonical envelope.
+func CanonicalEnvelope(e *snapCore.EncryptedBrigade) ([]byte, error) {
+	fp, size := e.PayloadSHA256, e.PayloadSize
+
+	if fp == "" {
+		sum := newPayloadSum()
+		if _, err := io.Copy(sum, base64.NewDecoder(base64.StdEncoding, strings.NewReader(e.Payload))); err != nil {
+			return nil, fmt.Errorf("decode payload: %w", err)
+		}
+
+		fp, size = sum.Sum()
+	}
+
+	return canonicalEnvelope(e, fp, size)
+}
+
+// SnapshotID returns the snapshot ID.
+func SnapshotID(e *snapCore.EncryptedBrigade) (string, error) {
+	data, err := CanonicalEnvelope(e)
+	if err != nil {
+		return "", err
+	}
+
+	return snapshotID(data), nil
+}
+
+// payloadRef returns the detached payload reference
+// or the sum of the streamed inline payload.
+func payloadRef(e *snapCore.EncryptedBrigade, sum *payloadSum) (string, int64) {
+	if e.PayloadSHA256 != "" {
+		return e.PayloadSHA256, e.PayloadSize
+	}
+
+	return sum.Sum()
+}
+
+func canonicalEnvelope(e *snapCore.EncryptedBrigade, fp string, size int64) ([]byte, error) {
+	c := *e
+	c.Version = 0
+	c.Payload = ""
+	c.PayloadSHA256, c.PayloadSize = fp, size
+
+	data, err := json.Marshal(&c)
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+
+	data, err = snapDelta.Canonical(data)
+	if err != nil {
+		return nil, fmt.Errorf("canonical: %w", err)
+	}
+
+	return data, nil
+}
+
+func snapshotID(canonical []byte) string {
+	sum := sha256.Sum256(canonical)
+
+	return hex.EncodeToString(sum[:])
+}
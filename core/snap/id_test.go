@@ -0,0 +1,88 @@
+package snap
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+)
+
+func Test_SnapshotID(t *testing.T) {
+	keys := genTestKeys(t)
+	psk := []byte("0123456789abcdef0123456789abcdef")
+	data := `{"brigade_id":"brigade1","version":12}`
+
+	opts := keys.snapOpts(t, psk)
+	opts.DigestHMAC = true
+
+	w := &bytes.Buffer{}
+
+	id, err := MakeSnapshotIDTo(w, strings.NewReader(data), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(id) != 64 {
+		t.Fatalf("MakeSnapshotIDTo() id = %q, want hex sha256", id)
+	}
+
+	e, err := DecodeEnvelope(w.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(name string, e *snapCore.EncryptedBrigade) {
+		t.Helper()
+
+		got, err := SnapshotID(e)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if got != id {
+			t.Errorf("%s: SnapshotID() = %s, want %s", name, got, id)
+		}
+	}
+
+	check("decoded", e)
+
+	bin := &bytes.Buffer{}
+	if err := EncodeEnvelope(bin, e, snapCore.EncodingBinary); err != nil {
+		t.Fatal(err)
+	}
+
+	be, err := DecodeBinaryEnvelope(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check("binary", be)
+
+	dir := t.TempDir()
+	if err := DetachPayload(be, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	check("detached", be)
+
+	v2 := *e
+	v2.Version = snapCore.EnvelopeVersion2
+	check("v2", &v2)
+
+	other := *e
+	other.BrigadeID = "brigade2"
+
+	if got, _ := SnapshotID(&other); got == id {
+		t.Error("SnapshotID() doesn't depend on the brigade ID")
+	}
+
+	again, err := MakeSnapshotIDTo(&bytes.Buffer{}, strings.NewReader(data), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again == id {
+		t.Error("MakeSnapshotIDTo() returns the same ID for the retaken snapshot")
+	}
+}
@@ -0,0 +1,171 @@
+package snap
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+)
+
+// MaxClockSkew is a maximum allowed difference of the host
+// and the realm clocks in the snapshot times.
+const MaxClockSkew = 5 * time.Minute
+
+var (
+	ErrUnknownRealmKey     = errors.New("unknown realm key")
+	ErrUnknownAuthorityKey = errors.New("unknown authority key")
+	ErrNoAuthorityKeys     = errors.New("no authority keys")
+	ErrTimeOrder           = errors.New("local snapshot time before global")
+	ErrFutureTime          = errors.New("snapshot time in future")
+)
+
+// KnownKeys are the fingerprints of the realm and authorities keys
+// to check the envelope against. Nil list skips the check.
+type KnownKeys struct {
+	Realms      []string
+	Authorities []string
+}
+
+// Inspection is a summary of the envelope which needs no keys.
+type Inspection struct {
+	ID        string `json:"id"`
+	Version   int    `json:"version"`
+	Tag       string `json:"tag"`
+	BrigadeID string `json:"brigade_id"`
+
+	GlobalSnapAt time.Time `json:"global_snap_at"`
+	LocalSnapAt  time.Time `json:"local_snap_at"`
+
+	RealmKeyFP      string   `json:"realm_key_fp"`
+	AuthorityKeyFPs []string `json:"authority_key_fps"`
+
+	// PayloadSize is a size of the encrypted payload.
+	PayloadSize     int64  `json:"payload_size"`
+	Detached        bool   `json:"detached"`
+	PayloadFormat   string `json:"payload_format,omitempty"`
+	Compression     string `json:"compression"`
+	PSKDerivation   string `json:"psk_derivation"`
+	PlaintextDigest string `json:"plaintext_digest"`
+	DigestHMAC      bool   `json:"digest_hmac"`
+	HeaderHMAC      bool   `json:"header_hmac"`
+
+	BaseTag         string `json:"base_tag,omitempty"`
+	RedactionPolicy string `json:"redaction_policy,omitempty"`
+	StorageVersion  int    `json:"storage_version,omitempty"`
+	KeydeskVersion  string `json:"keydesk_version,omitempty"`
+
+	Meta *snapCore.SnapshotMeta `json:"meta,omitempty"`
+
+	// Problems are the found inconsistencies, each one
+	// wraps one of the package or core errors.
+	Problems []error `json:"-"`
+}
+
+// Inspect returns the summary of the envelope and its problems
+// as of the time.
+func Inspect(e *snapCore.EncryptedBrigade, keys KnownKeys, now time.Time) (*Inspection, error) {
+	id, err := SnapshotID(e)
+	if err != nil {
+		return nil, fmt.Errorf("id: %w", err)
+	}
+
+	version := e.Version
+	if version == 0 {
+		version = snapCore.EnvelopeVersion1
+	}
+
+	auths := make([]string, 0, len(e.Secrets))
+	for fp := range e.Secrets {
+		auths = append(auths, fp)
+	}
+
+	slices.Sort(auths)
+
+	in := &Inspection{
+		ID:        id,
+		Version:   version,
+		Tag:       e.Tag,
+		BrigadeID: e.BrigadeID,
+
+		GlobalSnapAt: e.GlobalSnapAt,
+		LocalSnapAt:  e.LocalSnapAt,
+
+		RealmKeyFP:      e.RealmKeyFP,
+		AuthorityKeyFPs: auths,
+
+		PayloadSize:     e.PayloadSize,
+		Detached:        e.PayloadSHA256 != "",
+		PayloadFormat:   e.PayloadFormat,
+		Compression:     compressionAlgorithm(e.Compression),
+		PSKDerivation:   e.PSKDerivation,
+		PlaintextDigest: e.PlaintextDigest,
+		DigestHMAC:      e.DigestHMAC != "",
+		HeaderHMAC:      e.HeaderHMAC != "",
+
+		BaseTag:         e.BaseTag,
+		RedactionPolicy: e.RedactionPolicy,
+		StorageVersion:  e.StorageVersion,
+		KeydeskVersion:  e.KeydeskVersion,
+
+		Meta: e.Meta,
+	}
+
+	if !in.Detached {
+		in.PayloadSize = base64DecodedLen(e.Payload)
+	}
+
+	in.Problems = inspectProblems(e, auths, keys, now)
+
+	return in, nil
+}
+
+func inspectProblems(e *snapCore.EncryptedBrigade, auths []string, keys KnownKeys, now time.Time) []error {
+	var problems []error
+
+	if e.Version >= snapCore.TagGrammarEnvelopeVersion {
+		tag, err := snapCore.ParseTag(e.Tag)
+		if err != nil {
+			problems = append(problems, err)
+		} else if err := tag.CheckTime(e.GlobalSnapAt); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	if e.LocalSnapAt.Add(MaxClockSkew).Before(e.GlobalSnapAt) {
+		problems = append(problems, fmt.Errorf("%w: %s < %s", ErrTimeOrder, e.LocalSnapAt.Format(time.RFC3339), e.GlobalSnapAt.Format(time.RFC3339)))
+	}
+
+	for _, t := range []time.Time{e.GlobalSnapAt, e.LocalSnapAt} {
+		if t.After(now.Add(MaxClockSkew)) {
+			problems = append(problems, fmt.Errorf("%w: %s", ErrFutureTime, t.Format(time.RFC3339)))
+		}
+	}
+
+	if len(auths) == 0 {
+		problems = append(problems, ErrNoAuthorityKeys)
+	}
+
+	if keys.Realms != nil && !slices.Contains(keys.Realms, e.RealmKeyFP) {
+		problems = append(problems, fmt.Errorf("%w: %s", ErrUnknownRealmKey, e.RealmKeyFP))
+	}
+
+	if keys.Authorities != nil {
+		for _, fp := range auths {
+			if !slices.Contains(keys.Authorities, fp) {
+				problems = append(problems, fmt.Errorf("%w: %s", ErrUnknownAuthorityKey, fp))
+			}
+		}
+	}
+
+	return problems
+}
+
+// base64DecodedLen returns the exact decoded size of the padded base64.
+func base64DecodedLen(s string) int64 {
+	n := int64(len(s)) / 4 * 3
+
+	return n - int64(len(s)-len(strings.TrimRight(s, "=")))
+}
@@ -0,0 +1,134 @@
+package snap
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+)
+
+func Test_Inspect(t *testing.T) {
+	keys := genTestKeys(t)
+	psk := []byte("0123456789abcdef0123456789abcdef")
+
+	opts := keys.snapOpts(t, psk)
+	opts.Tag = "2023-11-14T22:13:20Z-manual-once-inspect"
+	opts.DigestHMAC = true
+
+	w := &bytes.Buffer{}
+	if err := MakeSnapshotTo(w, strings.NewReader(`{"brigade_id":"brigade1"}`), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	known := KnownKeys{Realms: []string{opts.RealFP}, Authorities: []string{opts.AuthKeys[0].FingerPrint}}
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		modify func(e *snapCore.EncryptedBrigade)
+		keys   KnownKeys
+		want   []error
+	}{
+		{name: "valid", keys: known},
+		{name: "no known keys", modify: func(e *snapCore.EncryptedBrigade) { e.RealmKeyFP = "SHA256:other" }},
+		{name: "unknown realm key", modify: func(e *snapCore.EncryptedBrigade) { e.RealmKeyFP = "SHA256:other" }, keys: known, want: []error{ErrUnknownRealmKey}},
+		{
+			name:   "unknown authority key",
+			modify: func(e *snapCore.EncryptedBrigade) { e.Secrets["SHA256:other"] = "secret" },
+			keys:   known,
+			want:   []error{ErrUnknownAuthorityKey},
+		},
+		{name: "no authority keys", modify: func(e *snapCore.EncryptedBrigade) { e.Secrets = nil }, want: []error{ErrNoAuthorityKeys}},
+		{name: "tag time", modify: func(e *snapCore.EncryptedBrigade) { e.GlobalSnapAt = e.GlobalSnapAt.Add(time.Hour) }, want: []error{snapCore.ErrTagTime}},
+		{name: "empty inline payload", modify: func(e *snapCore.EncryptedBrigade) { e.Payload = "" }},
+		{name: "invalid tag", modify: func(e *snapCore.EncryptedBrigade) { e.Tag = "snapshot" }, want: []error{snapCore.ErrInvalidTag}},
+		{
+			name:   "free form tag before grammar",
+			modify: func(e *snapCore.EncryptedBrigade) { e.Tag, e.Version = "snapshot", snapCore.EnvelopeVersion3 },
+		},
+		{
+			name:   "time order",
+			modify: func(e *snapCore.EncryptedBrigade) { e.LocalSnapAt = e.GlobalSnapAt.Add(-time.Hour) },
+			want:   []error{ErrTimeOrder},
+		},
+		{
+			name:   "future time",
+			modify: func(e *snapCore.EncryptedBrigade) { e.LocalSnapAt = now.Add(time.Hour) },
+			want:   []error{ErrFutureTime},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := DecodeEnvelope(w.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.modify != nil {
+				tt.modify(e)
+			}
+
+			in, err := Inspect(e, tt.keys, now)
+			if err != nil {
+				t.Fatalf("Inspect() error = %v", err)
+			}
+
+			if len(in.Problems) != len(tt.want) {
+				t.Fatalf("Inspect() problems = %v, want %v", in.Problems, tt.want)
+			}
+
+			for i, want := range tt.want {
+				if !errors.Is(in.Problems[i], want) {
+					t.Errorf("Inspect() problem %d = %v, want %v", i, in.Problems[i], want)
+				}
+			}
+
+			if in.Tag != e.Tag || in.BrigadeID != e.BrigadeID || in.Version != e.Version || !in.DigestHMAC {
+				t.Errorf("Inspect() = %+v, want the envelope fields", in)
+			}
+
+			payload, err := base64.StdEncoding.DecodeString(e.Payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if in.Detached || in.PayloadSize != int64(len(payload)) {
+				t.Errorf("Inspect() payload size = %d, want %d inline", in.PayloadSize, len(payload))
+			}
+		})
+	}
+}
+
+func Test_Inspect_Detached(t *testing.T) {
+	keys := genTestKeys(t)
+
+	w := &bytes.Buffer{}
+	if err := MakeSnapshotTo(w, strings.NewReader(`{"brigade_id":"brigade1"}`), keys.snapOpts(t, []byte("0123456789abcdef0123456789abcdef"))); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := DecodeEnvelope(w.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := base64DecodedLen(e.Payload)
+
+	if err := DetachPayload(e, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := Inspect(e, KnownKeys{}, time.Now())
+	if err != nil {
+		t.Fatalf("Inspect() error = %v", err)
+	}
+
+	if !in.Detached || in.PayloadSize != size {
+		t.Errorf("Inspect() detached = %t, payload size = %d, want detached %d", in.Detached, in.PayloadSize, size)
+	}
+}
@@ -0,0 +1,79 @@
+package snap
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	"golang.org/x/crypto/hkdf"
+)
+
+// headerHMACInfo is a HKDF info to derive the header HMAC key
+// from the brigade PSK and the locker secret.
+const headerHMACInfo = "vpngen-keydesk-snap-header-hmac"
+
+var (
+	ErrHeaderHMACMismatch = fmt.Errorf("header hmac mismatch")
+	ErrNoHeaderHMAC       = fmt.Errorf("no header hmac")
+)
+
+// headerHMAC authenticates the canonical envelope with the key derived
+// from the brigade PSK and the locker secret. The realm holds both: it
+// issues the PSK and decrypts the locker secret with its key, so the
+// ingest checks the header without the authority keys. The locker secret
+// alone is not enough, anyone can encrypt the own one to the realm key.
+func headerHMAC(psk, lockerSecret, canonical []byte) ([]byte, error) {
+	ikm := append(append([]byte{}, psk...), lockerSecret...)
+
+	key := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ikm, nil, []byte(headerHMACInfo)), key); err != nil {
+		return nil, fmt.Errorf("hkdf: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(canonical)
+
+	return mac.Sum(nil), nil
+}
+
+// VerifyHeaderHMAC checks the header HMAC of the envelope with the brigade
+// PSK and the decrypted locker secret, see BrigadePSK and DecryptLockerSecret.
+// The header, the meta included, can be trusted only after the check.
+// The detached payload is checked against the envelope reference when
+// it is read.
+func VerifyHeaderHMAC(e *snapCore.EncryptedBrigade, psk, lockerSecret []byte) error {
+	if e.HeaderHMAC == "" {
+		return ErrNoHeaderHMAC
+	}
+
+	if len(psk) == 0 {
+		return ErrEmptyPSK
+	}
+
+	want, err := base64.StdEncoding.DecodeString(e.HeaderHMAC)
+	if err != nil {
+		return fmt.Errorf("decode header hmac: %w", err)
+	}
+
+	c := *e
+	c.HeaderHMAC = ""
+
+	canonical, err := CanonicalEnvelope(&c)
+	if err != nil {
+		return fmt.Errorf("canonical: %w", err)
+	}
+
+	mac, err := headerHMAC(psk, lockerSecret, canonical)
+	if err != nil {
+		return fmt.Errorf("header hmac: %w", err)
+	}
+
+	if !hmac.Equal(mac, want) {
+		return ErrHeaderHMACMismatch
+	}
+
+	return nil
+}
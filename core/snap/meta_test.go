@@ -0,0 +1,219 @@
+package snap
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	snapCrypto "github.com/vpngen/keydesk-snap/core/crypto"
+)
+
+func Test_Meta(t *testing.T) {
+	keys := genTestKeys(t)
+	psk := []byte("0123456789abcdef0123456789abcdef")
+	data := `{"brigade_id":"brigade1","version":12}`
+
+	for _, encoding := range []string{snapCore.EncodingJSON, snapCore.EncodingBinary} {
+		t.Run(encoding, func(t *testing.T) {
+			opts := keys.snapOpts(t, psk)
+			opts.Encoding = encoding
+			opts.DigestHMAC = true
+			opts.Meta = func() (*snapCore.SnapshotMeta, error) {
+				return &snapCore.SnapshotMeta{Users: 3, KeydeskVersion: "v1.2.3", MaintenanceTill: 1700000000}, nil
+			}
+
+			w := &bytes.Buffer{}
+
+			id, err := MakeSnapshotIDTo(w, strings.NewReader(data), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			e, err := decodeSnapshot(w.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := &snapCore.SnapshotMeta{Users: 3, PlaintextSize: int64(len(data)), KeydeskVersion: "v1.2.3", MaintenanceTill: 1700000000}
+			if !reflect.DeepEqual(e.Meta, want) || e.HeaderHMAC == "" {
+				t.Fatalf("decoded meta = %+v, header hmac = %q, want %+v", e.Meta, e.HeaderHMAC, want)
+			}
+
+			if got, err := SnapshotID(e); err != nil || got != id {
+				t.Errorf("SnapshotID() = %s, %v, want %s", got, err, id)
+			}
+
+			ropts := keys.restoreOpts(t, e)
+			ropts.PSK = psk
+
+			if got, err := OpenSnapshot(e, ropts); err != nil || string(got) != data {
+				t.Fatalf("OpenSnapshot() = %s, %v, want %s", got, err, data)
+			}
+
+			for name, modify := range map[string]func(e *snapCore.EncryptedBrigade){
+				"meta":            func(e *snapCore.EncryptedBrigade) { e.Meta = &snapCore.SnapshotMeta{Users: 4} },
+				"storage version": func(e *snapCore.EncryptedBrigade) { e.StorageVersion++ },
+				"payload":         func(e *snapCore.EncryptedBrigade) { e.Payload = e.Payload[4:] },
+			} {
+				tampered := *e
+				modify(&tampered)
+
+				if _, err := OpenSnapshot(&tampered, ropts); !errors.Is(err, ErrHeaderHMACMismatch) {
+					t.Errorf("%s: OpenSnapshot() error = %v, want %v", name, err, ErrHeaderHMACMismatch)
+				}
+			}
+
+			stripped := *e
+			stripped.HeaderHMAC = ""
+
+			if _, err := OpenSnapshot(&stripped, ropts); !errors.Is(err, ErrNoHeaderHMAC) {
+				t.Errorf("stripped: OpenSnapshot() error = %v, want %v", err, ErrNoHeaderHMAC)
+			}
+		})
+	}
+}
+
+func Test_VerifyHeaderHMAC(t *testing.T) {
+	keys := genTestKeys(t)
+	master := bytes.Repeat([]byte{0x42}, snapCore.PSKSize)
+
+	opts := keys.snapOpts(t, master)
+	opts.PSKDerivation = snapCore.PSKDerivationHKDFSHA256
+	opts.Meta = func() (*snapCore.SnapshotMeta, error) {
+		return &snapCore.SnapshotMeta{Users: 3}, nil
+	}
+
+	buf, err := MakeSnapshot(strings.NewReader(`{"brigade_id":"brigade1"}`), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := DecodeEnvelope(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the ingest holds the master PSK and the realm key only
+	psk, err := BrigadePSK(e, master)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locker, err := DecryptLockerSecret(e, keys.realm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyHeaderHMAC(e, psk, locker); err != nil {
+		t.Fatalf("VerifyHeaderHMAC() error = %v", err)
+	}
+
+	// the own locker secret encrypted to the public realm key
+	// doesn't forge the meta without the PSK
+	forged := *e
+	forged.Meta = &snapCore.SnapshotMeta{Users: 1000}
+
+	own := bytes.Repeat([]byte{0x01}, LockerSecretSize)
+
+	encrypted, err := snapCrypto.EncryptSecret(&keys.realm.PublicKey, own)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged.EncryptedLockerSecret = base64.StdEncoding.EncodeToString(encrypted)
+	signHeader(t, &forged, bytes.Repeat([]byte{0x43}, snapCore.PSKSize), own)
+
+	locker, err = DecryptLockerSecret(&forged, keys.realm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyHeaderHMAC(&forged, psk, locker); !errors.Is(err, ErrHeaderHMACMismatch) {
+		t.Errorf("forged: VerifyHeaderHMAC() error = %v, want %v", err, ErrHeaderHMACMismatch)
+	}
+}
+
+func Test_Meta_Padding(t *testing.T) {
+	keys := genTestKeys(t)
+
+	opts := keys.snapOpts(t, []byte("0123456789abcdef0123456789abcdef"))
+	opts.Padding = Padding{PowerOfTwo: true}
+	opts.Meta = func() (*snapCore.SnapshotMeta, error) {
+		return &snapCore.SnapshotMeta{Users: 3}, nil
+	}
+
+	if _, err := MakeSnapshotIDTo(&bytes.Buffer{}, strings.NewReader(`{}`), opts); !errors.Is(err, ErrMetaPadding) {
+		t.Errorf("MakeSnapshotIDTo() error = %v, want %v", err, ErrMetaPadding)
+	}
+}
+
+func Test_Meta_Schema(t *testing.T) {
+	data, err := Schema(snapCore.EnvelopeVersion4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := struct {
+		Properties struct {
+			Meta struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"meta"`
+		} `json:"properties"`
+	}{}
+
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	var fields, props []string
+
+	typ := reflect.TypeOf(snapCore.SnapshotMeta{})
+	for i := range typ.NumField() {
+		fields = append(fields, strings.Split(typ.Field(i).Tag.Get("json"), ",")[0])
+	}
+
+	for name := range schema.Properties.Meta.Properties {
+		props = append(props, name)
+	}
+
+	slices.Sort(fields)
+	slices.Sort(props)
+
+	if !slices.Equal(fields, props) {
+		t.Errorf("meta schema properties = %v, want %v", props, fields)
+	}
+}
+
+// signHeader replaces the header HMAC of the modified envelope.
+func signHeader(t *testing.T, e *snapCore.EncryptedBrigade, psk, lockerSecret []byte) {
+	t.Helper()
+
+	e.HeaderHMAC = ""
+
+	canonical, err := CanonicalEnvelope(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mac, err := headerHMAC(psk, lockerSecret, canonical)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.HeaderHMAC = base64.StdEncoding.EncodeToString(mac)
+}
+
+// decodeSnapshot decodes the envelope in any encoding.
+func decodeSnapshot(data []byte) (*snapCore.EncryptedBrigade, error) {
+	if IsBinaryEnvelope(data) {
+		return DecodeBinaryEnvelope(bytes.NewReader(data))
+	}
+
+	return DecodeEnvelope(data)
+}
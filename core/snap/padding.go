@@ -0,0 +1,155 @@
+package snap
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PaddingPowerOfTwo is a padding spec to pad up to the power of two.
+	PaddingPowerOfTwo = "pow2"
+	// MinPowerOfTwoBucket is a minimum bucket size of the power of two padding.
+	MinPowerOfTwoBucket = 4 * 1024
+	// MaxPaddingBucket is a maximum bucket size of the padding spec.
+	MaxPaddingBucket = 16 * 1024 * 1024 * 1024
+)
+
+var (
+	ErrInvalidPadding   = fmt.Errorf("invalid padding")
+	ErrPaddingNotFramed = fmt.Errorf("padding requires the framed payload with digest")
+	ErrMetaPadding      = fmt.Errorf("public meta discloses the padded size")
+)
+
+// Padding is a policy to pad the framed payload up to the size buckets
+// before the encryption, so the payload size doesn't leak the brigade size.
+// Zero Padding means no padding.
+type Padding struct {
+	// PowerOfTwo pads up to the next power of two.
+	PowerOfTwo bool
+	// Buckets is an ascending list of the bucket sizes.
+	// The size over the largest bucket is padded up to the multiple of it.
+	Buckets []int64
+}
+
+// ParsePadding parses the padding spec: empty, "pow2"
+// or a comma separated list of sizes with optional k, m, g suffixes.
+func ParsePadding(spec string) (Padding, error) {
+	spec = strings.TrimSpace(spec)
+
+	switch spec {
+	case "":
+		return Padding{}, nil
+	case PaddingPowerOfTwo:
+		return Padding{PowerOfTwo: true}, nil
+	}
+
+	p := Padding{}
+
+	for _, s := range strings.Split(spec, ",") {
+		size, err := parseSize(strings.TrimSpace(s))
+		if err != nil {
+			return Padding{}, fmt.Errorf("%w: %q: %w", ErrInvalidPadding, s, err)
+		}
+
+		p.Buckets = append(p.Buckets, size)
+	}
+
+	slices.Sort(p.Buckets)
+	p.Buckets = slices.Compact(p.Buckets)
+
+	return p, nil
+}
+
+// IsZero reports whether the padding is off.
+func (p Padding) IsZero() bool {
+	return !p.PowerOfTwo && len(p.Buckets) == 0
+}
+
+// Target returns the padded size for the size.
+func (p Padding) Target(size int64) int64 {
+	switch {
+	case p.PowerOfTwo:
+		if size <= MinPowerOfTwoBucket {
+			return MinPowerOfTwoBucket
+		}
+
+		if size > math.MaxInt64/2+1 {
+			return size
+		}
+
+		return 1 << bits.Len64(uint64(size-1))
+	case len(p.Buckets) > 0:
+		for _, bucket := range p.Buckets {
+			if size <= bucket {
+				return bucket
+			}
+		}
+
+		largest := p.Buckets[len(p.Buckets)-1]
+		if size > math.MaxInt64-largest {
+			return size
+		}
+
+		return (size + largest - 1) / largest * largest
+	default:
+		return size
+	}
+}
+
+// writePadding writes zeros up to the padded size.
+func writePadding(w io.Writer, p Padding, size int64) error {
+	left := p.Target(size) - size
+	if left <= 0 {
+		return nil
+	}
+
+	zeros := make([]byte, min(left, StreamBufferSize))
+
+	for left > 0 {
+		n, err := w.Write(zeros[:min(left, int64(len(zeros)))])
+		if err != nil {
+			return fmt.Errorf("write: %w", err)
+		}
+
+		left -= int64(n)
+	}
+
+	return nil
+}
+
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mult = 1024
+	case strings.HasSuffix(s, "m"):
+		mult = 1024 * 1024
+	case strings.HasSuffix(s, "g"):
+		mult = 1024 * 1024 * 1024
+	}
+
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if n <= 0 {
+		return 0, fmt.Errorf("not positive: %d", n)
+	}
+
+	if n > MaxPaddingBucket/mult {
+		return 0, fmt.Errorf("bigger than %d: %s", int64(MaxPaddingBucket), s)
+	}
+
+	return n * mult, nil
+}
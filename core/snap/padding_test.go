@@ -0,0 +1,113 @@
+package snap
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+)
+
+func Test_ParsePadding(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Padding
+		wantErr bool
+	}{
+		{spec: "", want: Padding{}},
+		{spec: "pow2", want: Padding{PowerOfTwo: true}},
+		{spec: "1m,64k,64k,4096", want: Padding{Buckets: []int64{4096, 64 * 1024, 1024 * 1024}}},
+		{spec: "0", wantErr: true},
+		{spec: "64x", wantErr: true},
+		{spec: "64k,", wantErr: true},
+		{spec: "16g", want: Padding{Buckets: []int64{MaxPaddingBucket}}},
+		{spec: "16385m", wantErr: true},
+		{spec: "9223372036854775807", wantErr: true},
+		{spec: "8589934592g", wantErr: true},
+		{spec: "9007199254740992k", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePadding(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePadding(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+
+			continue
+		}
+
+		if got.PowerOfTwo != tt.want.PowerOfTwo || !slices.Equal(got.Buckets, tt.want.Buckets) {
+			t.Errorf("ParsePadding(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func Test_Padding_Target(t *testing.T) {
+	pow2 := Padding{PowerOfTwo: true}
+	buckets := Padding{Buckets: []int64{1000, 5000}}
+
+	tests := []struct {
+		p    Padding
+		size int64
+		want int64
+	}{
+		{p: Padding{}, size: 123, want: 123},
+		{p: pow2, size: 1, want: MinPowerOfTwoBucket},
+		{p: pow2, size: MinPowerOfTwoBucket + 1, want: 2 * MinPowerOfTwoBucket},
+		{p: pow2, size: 1 << 20, want: 1 << 20},
+		{p: buckets, size: 1000, want: 1000},
+		{p: buckets, size: 1001, want: 5000},
+		{p: buckets, size: 12000, want: 15000},
+		{p: Padding{Buckets: []int64{MaxPaddingBucket}}, size: math.MaxInt64 - 10, want: math.MaxInt64 - 10},
+		{p: pow2, size: math.MaxInt64 - 10, want: math.MaxInt64 - 10},
+	}
+
+	for _, tt := range tests {
+		if got := tt.p.Target(tt.size); got != tt.want {
+			t.Errorf("%+v.Target(%d) = %d, want %d", tt.p, tt.size, got, tt.want)
+		}
+	}
+}
+
+func Test_Payload_Padding(t *testing.T) {
+	secret := []byte("my super secret password")
+	opts := PayloadOpts{
+		Compression: snapCore.CompressionNone,
+		Digest:      snapCore.DigestSHA256,
+		Padding:     Padding{PowerOfTwo: true},
+	}
+
+	sizes := map[int]bool{}
+
+	for _, data := range []string{"", "Lorem ipsum dui.", strings.Repeat("Lorem ipsum dui.", 200)} {
+		encrypted := &bytes.Buffer{}
+
+		if _, err := CompressEncryptSnapshotTo(encrypted, strings.NewReader(data), secret, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		sizes[encrypted.Len()] = true
+
+		decrypted := &bytes.Buffer{}
+		if _, err := DecryptDecompressSnapshotTo(decrypted, encrypted, secret, opts); err != nil {
+			t.Fatalf("DecryptDecompressSnapshotTo() error = %v", err)
+		}
+
+		if decrypted.String() != data {
+			t.Errorf("DecryptDecompressSnapshotTo() len = %d, want %d", decrypted.Len(), len(data))
+		}
+	}
+
+	if len(sizes) != 1 {
+		t.Errorf("CompressEncryptSnapshotTo() sizes = %v, want the single bucket", sizes)
+	}
+
+	opts.Digest = ""
+
+	_, err := CompressEncryptSnapshotTo(&bytes.Buffer{}, strings.NewReader("Lorem ipsum dui."), secret, opts)
+	if !errors.Is(err, ErrPaddingNotFramed) {
+		t.Errorf("CompressEncryptSnapshotTo() error = %v, want %v", err, ErrPaddingNotFramed)
+	}
+}
@@ -0,0 +1,218 @@
+package snap
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	snapCrypto "github.com/vpngen/keydesk-snap/core/crypto"
+	"golang.org/x/crypto/hkdf"
+)
+
+// digestHMACInfo is a HKDF info to derive the digest HMAC key from the final secret.
+const digestHMACInfo = "vpngen-keydesk-snap-digest-hmac"
+
+var (
+	ErrUnknownDigest      = fmt.Errorf("unknown digest")
+	ErrDigestMismatch     = fmt.Errorf("plaintext digest mismatch")
+	ErrDigestHMACMismatch = fmt.Errorf("plaintext digest hmac mismatch")
+)
+
+// PayloadOpts describes the payload encoding inside the encryption.
+type PayloadOpts struct {
+	// Compression is a compression algorithm. Empty means gzip.
+	Compression string
+	// CompressionLevel is an algorithm specific level.
+	// Zero means the algorithm default level.
+	CompressionLevel int
+	// Digest is a plaintext digest algorithm.
+	// The payload is framed and the digest is stored in the trailer.
+	// Empty means the compressed stream as is, without the digest.
+	Digest string
+	// Padding is a size padding policy of the framed payload.
+	// It is stripped on decryption, so it is not needed there.
+	Padding Padding
+}
+
+// PayloadInfo is a result of the payload processing.
+type PayloadInfo struct {
+	// PlainSize is a size of the plaintext.
+	PlainSize int64
+	// Digest is a plaintext digest, nil if there is no digest.
+	Digest []byte
+}
+
+// CompressEncryptSnapshotTo streams the compressed and encrypted data to the writer.
+func CompressEncryptSnapshotTo(w io.Writer, r io.Reader, secret []byte, opts PayloadOpts) (*PayloadInfo, error) {
+	if len(secret) == 0 {
+		return nil, snapCrypto.ErrEmptySecret
+	}
+
+	h, err := newDigest(opts.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	if h == nil && !opts.Padding.IsZero() {
+		return nil, ErrPaddingNotFramed
+	}
+
+	pr, pw := io.Pipe()
+	defer pr.Close() // unblock the compressor if the encryption fails
+
+	info := &PayloadInfo{}
+
+	go func() {
+		pw.CloseWithError(compressPayload(pw, r, opts, h, info))
+	}()
+
+	if err := snapCrypto.EncryptAES256CBC(pr, w, secret); err != nil {
+		return nil, fmt.Errorf("encrypt: %w", err)
+	}
+
+	return info, nil
+}
+
+// compressPayload compresses and frames the plaintext.
+func compressPayload(w io.Writer, r io.Reader, opts PayloadOpts, h hash.Hash, info *PayloadInfo) error {
+	var (
+		fw  *frameWriter
+		bw  *bufio.Writer
+		dst = w
+	)
+
+	if h != nil {
+		fw = newFrameWriter(w)
+		bw = bufio.NewWriterSize(fw, StreamBufferSize)
+		dst = bw
+		r = io.TeeReader(r, h)
+	}
+
+	wz, err := newCompressor(dst, opts.Compression, opts.CompressionLevel)
+	if err != nil {
+		return fmt.Errorf("compressor: %w", err)
+	}
+
+	n, err := io.CopyBuffer(wz, r, make([]byte, StreamBufferSize))
+	if err != nil {
+		return fmt.Errorf("compress: %w", err)
+	}
+
+	if err := wz.Close(); err != nil {
+		return fmt.Errorf("compress: %w", err)
+	}
+
+	info.PlainSize = n
+
+	if h == nil {
+		return nil
+	}
+
+	info.Digest = h.Sum(nil)
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+
+	if err := fw.Close(info.Digest); err != nil {
+		return fmt.Errorf("trailer: %w", err)
+	}
+
+	if err := writePadding(w, opts.Padding, fw.Size()); err != nil {
+		return fmt.Errorf("padding: %w", err)
+	}
+
+	return nil
+}
+
+// DecryptDecompressSnapshotTo streams the decrypted and decompressed data to the writer.
+// If the payload has the digest it is verified at the end of the stream,
+// so on error the written data must be discarded.
+func DecryptDecompressSnapshotTo(w io.Writer, r io.Reader, secret []byte, opts PayloadOpts) (*PayloadInfo, error) {
+	if len(secret) == 0 {
+		return nil, snapCrypto.ErrEmptySecret
+	}
+
+	h, err := newDigest(opts.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	defer pr.Close() // unblock the decryption if the decompression fails
+
+	go func() {
+		pw.CloseWithError(snapCrypto.DecryptAES256CBC(r, pw, secret))
+	}()
+
+	var (
+		fr  *frameReader
+		src io.Reader = pr
+	)
+
+	if h != nil {
+		fr = newFrameReader(bufio.NewReaderSize(pr, StreamBufferSize))
+		src = fr
+		w = io.MultiWriter(w, h)
+	}
+
+	rz, err := newDecompressor(src, opts.Compression)
+	if err != nil {
+		return nil, fmt.Errorf("decompressor: %w", err)
+	}
+
+	defer rz.Close()
+
+	n, err := io.CopyBuffer(w, rz, make([]byte, StreamBufferSize))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt decompress: %w", err)
+	}
+
+	info := &PayloadInfo{PlainSize: n}
+
+	if h == nil {
+		return info, nil
+	}
+
+	digest, err := fr.Trailer(h.Size())
+	if err != nil {
+		return nil, fmt.Errorf("digest: %w", err)
+	}
+
+	info.Digest = h.Sum(nil)
+
+	if !hmac.Equal(digest, info.Digest) {
+		return nil, ErrDigestMismatch
+	}
+
+	return info, nil
+}
+
+// newDigest returns the hash for the digest algorithm, nil if there is no digest.
+func newDigest(alg string) (hash.Hash, error) {
+	switch alg {
+	case "":
+		return nil, nil
+	case snapCore.DigestSHA256:
+		return sha256.New(), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDigest, alg)
+	}
+}
+
+// digestHMAC authenticates the plaintext digest with the key derived from the final secret.
+func digestHMAC(secret, digest []byte) ([]byte, error) {
+	key := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte(digestHMACInfo)), key); err != nil {
+		return nil, fmt.Errorf("hkdf: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(digest)
+
+	return mac.Sum(nil), nil
+}
@@ -0,0 +1,96 @@
+package snap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"testing"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+)
+
+func Test_Payload_Digest(t *testing.T) {
+	secret := []byte("my super secret password")
+
+	for _, alg := range []string{snapCore.CompressionNone, snapCore.CompressionGzip, snapCore.CompressionZstd} {
+		for _, data := range []string{"", "Lorem ipsum dui.", strings.Repeat("Lorem ipsum dui.", StreamBufferSize/8)} {
+			opts := PayloadOpts{Compression: alg, Digest: snapCore.DigestSHA256}
+
+			encrypted := &bytes.Buffer{}
+
+			info, err := CompressEncryptSnapshotTo(encrypted, strings.NewReader(data), secret, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := sha256.Sum256([]byte(data))
+			if !bytes.Equal(info.Digest, want[:]) || info.PlainSize != int64(len(data)) {
+				t.Errorf("%s: CompressEncryptSnapshotTo() info = %x/%d, want %x/%d", alg, info.Digest, info.PlainSize, want, len(data))
+			}
+
+			decrypted := &bytes.Buffer{}
+
+			got, err := DecryptDecompressSnapshotTo(decrypted, encrypted, secret, opts)
+			if err != nil {
+				t.Fatalf("%s: DecryptDecompressSnapshotTo() error = %v", alg, err)
+			}
+
+			if decrypted.String() != data || !bytes.Equal(got.Digest, want[:]) {
+				t.Errorf("%s: DecryptDecompressSnapshotTo() len = %d, want %d", alg, decrypted.Len(), len(data))
+			}
+		}
+	}
+}
+
+func Test_Payload_DigestMismatch(t *testing.T) {
+	secret := []byte("my super secret password")
+	opts := PayloadOpts{Compression: snapCore.CompressionNone}
+
+	// build the framed payload with a wrong digest by hand
+	plain := &bytes.Buffer{}
+	fw := newFrameWriter(plain)
+
+	if _, err := fw.Write([]byte("Lorem ipsum dui.")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fw.Close(make([]byte, sha256.Size)); err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted := &bytes.Buffer{}
+	if _, err := CompressEncryptSnapshotTo(encrypted, plain, secret, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Digest = snapCore.DigestSHA256
+
+	_, err := DecryptDecompressSnapshotTo(&bytes.Buffer{}, encrypted, secret, opts)
+	if !errors.Is(err, ErrDigestMismatch) {
+		t.Errorf("DecryptDecompressSnapshotTo() error = %v, want %v", err, ErrDigestMismatch)
+	}
+}
+
+func Test_Payload_Truncated(t *testing.T) {
+	secret := []byte("my super secret password")
+	opts := PayloadOpts{Compression: snapCore.CompressionNone}
+
+	// framed payload without the end of chunks and the trailer
+	plain := &bytes.Buffer{}
+	if _, err := newFrameWriter(plain).Write([]byte("Lorem ipsum dui.")); err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted := &bytes.Buffer{}
+	if _, err := CompressEncryptSnapshotTo(encrypted, plain, secret, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Digest = snapCore.DigestSHA256
+
+	_, err := DecryptDecompressSnapshotTo(&bytes.Buffer{}, encrypted, secret, opts)
+	if !errors.Is(err, ErrInvalidFrame) {
+		t.Errorf("DecryptDecompressSnapshotTo() error = %v, want %v", err, ErrInvalidFrame)
+	}
+}
@@ -0,0 +1,299 @@
+package snap
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+)
+
+// payloadPlaceholder stands for the spooled inline payload
+// while the rest of the envelope is checked by the version decoder.
+const payloadPlaceholder = `"AA=="`
+
+var ErrInvalidJSONEnvelope = errors.New("invalid json envelope")
+
+// ReadEnvelope decodes the JSON or binary envelope from the reader
+// without keeping the payload in memory. The inline payload is written
+// to the content addressed file in the dir, see DetachPayload, and the
+// returned envelope refers to it as detached, the version is kept.
+// It reports whether the payload is written to the dir.
+func ReadEnvelope(r io.Reader, dir string) (*snapCore.EncryptedBrigade, bool, error) {
+	br := bufio.NewReaderSize(r, StreamBufferSize)
+
+	pf, err := createPayloadFile(dir)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var fields map[string]json.RawMessage
+
+	if magic, _ := br.Peek(len(binaryMagic)); IsBinaryEnvelope(magic) {
+		fields, err = readBinaryEnvelope(br, pf)
+	} else {
+		fields, err = readJSONEnvelope(br, pf)
+	}
+
+	if err != nil {
+		pf.Abort()
+
+		return nil, false, err
+	}
+
+	if pf.sum.n == 0 {
+		pf.Abort()
+
+		e, err := decodeFields(fields)
+
+		return e, false, err
+	}
+
+	// the JSON tokenizer refuses the duplicate payload itself,
+	// the binary header and trailer must not have it at all
+	if _, ok := fields["payload"]; ok {
+		pf.Abort()
+
+		return nil, false, fmt.Errorf("%w: duplicate field payload", ErrInvalidEnvelope)
+	}
+
+	fields["payload"] = json.RawMessage(payloadPlaceholder)
+
+	e, err := decodeFields(fields)
+	if err != nil {
+		pf.Abort()
+
+		return nil, false, err
+	}
+
+	e.Payload = ""
+
+	e.PayloadSHA256, e.PayloadSize, err = pf.Commit()
+	if err != nil {
+		return nil, false, fmt.Errorf("commit: %w", err)
+	}
+
+	return e, true, nil
+}
+
+func decodeFields(fields map[string]json.RawMessage) (*snapCore.EncryptedBrigade, error) {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+
+	return DecodeEnvelope(data)
+}
+
+// readJSONEnvelope reads the top level object of the JSON envelope.
+// The base64 payload string is decoded to the writer on the fly,
+// the size of the other fields is limited by MaxBinaryHeaderSize.
+func readJSONEnvelope(r *bufio.Reader, payload io.Writer) (map[string]json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	size := 0
+
+	c, err := skipSpace(r)
+	if err != nil || c != '{' {
+		return nil, fmt.Errorf("%w: object expected", ErrInvalidJSONEnvelope)
+	}
+
+	if c, err = skipSpace(r); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidJSONEnvelope, err)
+	}
+
+	for c != '}' {
+		if c != '"' {
+			return nil, fmt.Errorf("%w: field name expected", ErrInvalidJSONEnvelope)
+		}
+
+		key, err := readJSONValue(r, '"', MaxBinaryHeaderSize-size)
+		if err != nil {
+			return nil, err
+		}
+
+		var name string
+		if err := json.Unmarshal(key, &name); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidJSONEnvelope, err)
+		}
+
+		if c, err = skipSpace(r); err != nil || c != ':' {
+			return nil, fmt.Errorf("%w: colon expected", ErrInvalidJSONEnvelope)
+		}
+
+		if c, err = skipSpace(r); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidJSONEnvelope, err)
+		}
+
+		if _, ok := fields[name]; ok {
+			return nil, fmt.Errorf("%w: duplicate field %s", ErrInvalidJSONEnvelope, name)
+		}
+
+		switch name {
+		case "payload":
+			if c != '"' {
+				return nil, fmt.Errorf("%w: payload is not a string", ErrInvalidJSONEnvelope)
+			}
+
+			src := &jsonStringReader{r: r}
+			if _, err := io.Copy(payload, base64.NewDecoder(base64.StdEncoding, src)); err != nil {
+				return nil, fmt.Errorf("%w: payload: %w", ErrInvalidJSONEnvelope, err)
+			}
+
+			// the payload is spooled, mark it as seen
+			fields[name] = nil
+		default:
+			value, err := readJSONValue(r, c, MaxBinaryHeaderSize-size)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+
+			size += len(key) + len(value)
+			fields[name] = value
+		}
+
+		if c, err = skipSpace(r); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidJSONEnvelope, err)
+		}
+
+		switch c {
+		case ',':
+			if c, err = skipSpace(r); err != nil || c == '}' {
+				return nil, fmt.Errorf("%w: field expected", ErrInvalidJSONEnvelope)
+			}
+		case '}':
+		default:
+			return nil, fmt.Errorf("%w: comma expected", ErrInvalidJSONEnvelope)
+		}
+	}
+
+	if _, err := skipSpace(r); !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: trailing data", ErrInvalidJSONEnvelope)
+	}
+
+	delete(fields, "payload")
+
+	return fields, nil
+}
+
+// readJSONValue reads the raw JSON value starting with the already read byte.
+func readJSONValue(r *bufio.Reader, first byte, limit int) (json.RawMessage, error) {
+	var (
+		value    = []byte{first}
+		depth    = 0
+		inString = first == '"'
+		escape   = false
+	)
+
+	switch first {
+	case '{', '[':
+		depth++
+	}
+
+	for inString || depth > 0 {
+		if len(value) > limit {
+			return nil, fmt.Errorf("%w: too big", ErrInvalidJSONEnvelope)
+		}
+
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidJSONEnvelope, io.ErrUnexpectedEOF)
+		}
+
+		value = append(value, c)
+
+		switch {
+		case escape:
+			escape = false
+		case inString && c == '\\':
+			escape = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+
+	// the scalars end with the delimiter
+	if first != '"' && first != '{' && first != '[' {
+		for {
+			c, err := r.ReadByte()
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidJSONEnvelope, io.ErrUnexpectedEOF)
+			}
+
+			if c == ',' || c == '}' || isSpace(c) {
+				r.UnreadByte()
+
+				break
+			}
+
+			if len(value) > limit {
+				return nil, fmt.Errorf("%w: too big", ErrInvalidJSONEnvelope)
+			}
+
+			value = append(value, c)
+		}
+	}
+
+	if !json.Valid(value) {
+		return nil, fmt.Errorf("%w: invalid value", ErrInvalidJSONEnvelope)
+	}
+
+	return value, nil
+}
+
+func skipSpace(r *bufio.Reader) (byte, error) {
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		if !isSpace(c) {
+			return c, nil
+		}
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// jsonStringReader reads the JSON string content up to the closing quote.
+// The base64 payload has no escapes, so they are refused.
+type jsonStringReader struct {
+	r    *bufio.Reader
+	done bool
+}
+
+func (s *jsonStringReader) Read(p []byte) (int, error) {
+	if s.done {
+		return 0, io.EOF
+	}
+
+	for n := range p {
+		c, err := s.r.ReadByte()
+		if err != nil {
+			return n, io.ErrUnexpectedEOF
+		}
+
+		switch c {
+		case '"':
+			s.done = true
+
+			return n, io.EOF
+		case '\\':
+			return n, fmt.Errorf("%w: escaped payload", ErrInvalidJSONEnvelope)
+		}
+
+		p[n] = c
+	}
+
+	return len(p), nil
+}
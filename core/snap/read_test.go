@@ -0,0 +1,112 @@
+package snap
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+)
+
+func Test_ReadEnvelope(t *testing.T) {
+	keys := genTestKeys(t)
+	psk := []byte("0123456789abcdef0123456789abcdef")
+	data := strings.Repeat(`{"brigade_id":"brigade1","version":12}`, StreamBufferSize/16)
+
+	for _, encoding := range []string{snapCore.EncodingJSON, snapCore.EncodingBinary} {
+		t.Run(encoding, func(t *testing.T) {
+			opts := keys.snapOpts(t, psk)
+			opts.Encoding = encoding
+			opts.DigestHMAC = true
+
+			env, err := MakeSnapshot(strings.NewReader(data), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want, err := decodeSnapshot(env)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wantID, err := SnapshotID(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dir := t.TempDir()
+
+			e, spooled, err := ReadEnvelope(bytes.NewReader(env), dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !spooled || e.Payload != "" || e.PayloadSHA256 == "" || e.Version != want.Version {
+				t.Fatalf("ReadEnvelope() = %+v, %t, want the spooled payload of version %d", e, spooled, want.Version)
+			}
+
+			id, err := SnapshotID(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if id != wantID {
+				t.Errorf("ReadEnvelope() snapshot ID = %s, want %s", id, wantID)
+			}
+
+			ropts := keys.restoreOpts(t, e)
+			ropts.PSK = psk
+			ropts.PayloadDir = dir
+
+			got, err := OpenSnapshot(e, ropts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != data {
+				t.Errorf("OpenSnapshot() len = %d, want %d", len(got), len(data))
+			}
+		})
+	}
+}
+
+func Test_ReadEnvelope_Invalid(t *testing.T) {
+	keys := genTestKeys(t)
+	psk := []byte("0123456789abcdef0123456789abcdef")
+
+	env, err := MakeSnapshot(strings.NewReader(`{"brigade_id":"brigade1"}`), keys.snapOpts(t, psk))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte(`"payload": "`)
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{name: "not object", data: []byte(`[]`), err: ErrInvalidJSONEnvelope},
+		{name: "trailing", data: append(bytes.Clone(env), "{}"...), err: ErrInvalidJSONEnvelope},
+		{name: "truncated", data: env[:len(env)-2], err: ErrInvalidJSONEnvelope},
+		{name: "escaped payload", data: bytes.Replace(env, payload, append(bytes.Clone(payload), `\u0041`...), 1), err: ErrInvalidJSONEnvelope},
+		{name: "duplicate payload", data: bytes.Replace(env, []byte(`"tag":`), []byte(`"payload": "AAAA", "tag":`), 1), err: ErrInvalidJSONEnvelope},
+		{name: "duplicate", data: bytes.Replace(env, []byte(`"tag":`), []byte(`"brigade_id": "x", "tag":`), 1), err: ErrInvalidJSONEnvelope},
+		{name: "no payload", data: bytes.Replace(env, payload, []byte(`"x": "`), 1), err: ErrInvalidEnvelope},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !bytes.Contains(env, payload) {
+				t.Fatalf("no %s in the envelope", payload)
+			}
+
+			dir := t.TempDir()
+
+			if _, _, err := ReadEnvelope(bytes.NewReader(tt.data), dir); !errors.Is(err, tt.err) {
+				t.Errorf("ReadEnvelope() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
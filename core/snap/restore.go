@@ -0,0 +1,187 @@
+package snap
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	snapCrypto "github.com/vpngen/keydesk-snap/core/crypto"
+)
+
+// RestoreOpts is a set of secrets to open the snapshot.
+type RestoreOpts struct {
+	// MasterPSK is a global PSK. The brigade PSK is derived from it
+	// the same way as it was done by the snapshot side.
+	MasterPSK []byte
+	// PSK is a brigade PSK, it is used as is.
+	// It is ignored if MasterPSK is set.
+	PSK []byte
+	// LockerSecret is a decrypted locker secret.
+	LockerSecret []byte
+	// Secret is a decrypted main secret.
+	Secret []byte
+	// PayloadDir is a dir of the detached payload files.
+	PayloadDir string
+}
+
+var (
+	ErrEmptyPSK             = fmt.Errorf("empty psk")
+	ErrAuthorityKeyNotFound = fmt.Errorf("authority key not found in snapshot")
+	ErrNoPSKDerivation      = fmt.Errorf("snapshot made without psk derivation")
+)
+
+// BrigadePSK returns the brigade PSK for the snapshot.
+// If the snapshot was made without the PSK derivation
+// the master PSK is returned as is.
+func BrigadePSK(e *snapCore.EncryptedBrigade, master []byte) ([]byte, error) {
+	if len(master) == 0 {
+		return nil, ErrEmptyPSK
+	}
+
+	return brigadePSK(e.PSKDerivation, master, e.Tag, e.BrigadeID, e.GlobalSnapAt)
+}
+
+// DerivedPSK returns the brigade PSK to hand out instead of the master PSK.
+// The snapshot made without the PSK derivation is refused, its brigade PSK
+// is the master PSK.
+func DerivedPSK(e *snapCore.EncryptedBrigade, master []byte) ([]byte, error) {
+	if e.PSKDerivation == "" {
+		return nil, ErrNoPSKDerivation
+	}
+
+	return BrigadePSK(e, master)
+}
+
+// DecryptLockerSecret decrypts the locker secret with the realm
+// (or authority) private key.
+func DecryptLockerSecret(e *snapCore.EncryptedBrigade, key *rsa.PrivateKey) ([]byte, error) {
+	secret, err := snapCrypto.DecryptRSAEncodedSecret(key, e.EncryptedLockerSecret)
+	if err != nil {
+		return nil, fmt.Errorf("locker secret: %w", err)
+	}
+
+	return secret, nil
+}
+
+// DecryptAuthoritySecret decrypts the main secret with the authority private key.
+func DecryptAuthoritySecret(e *snapCore.EncryptedBrigade, key *rsa.PrivateKey) ([]byte, error) {
+	fp, err := snapCrypto.FingerprintRSAPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("fingerprint: %w", err)
+	}
+
+	encoded, ok := e.Secrets[fp]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrAuthorityKeyNotFound, fp)
+	}
+
+	secret, err := snapCrypto.DecryptRSAEncodedSecret(key, encoded)
+	if err != nil {
+		return nil, fmt.Errorf("secret: %w", err)
+	}
+
+	return secret, nil
+}
+
+// OpenSnapshot decrypts and decompresses the snapshot payload in memory.
+func OpenSnapshot(e *snapCore.EncryptedBrigade, opts RestoreOpts) ([]byte, error) {
+	w := &bytes.Buffer{}
+
+	if err := OpenSnapshotTo(w, e, opts); err != nil {
+		return nil, err
+	}
+
+	return w.Bytes(), nil
+}
+
+// OpenSnapshotTo streams the decrypted and decompressed snapshot payload to the writer.
+// On error the written data must be discarded.
+func OpenSnapshotTo(w io.Writer, e *snapCore.EncryptedBrigade, opts RestoreOpts) error {
+	if len(e.Tag) == 0 {
+		return ErrEmptyTag
+	}
+
+	psk := opts.PSK
+	if len(opts.MasterPSK) > 0 {
+		var err error
+
+		psk, err = BrigadePSK(e, opts.MasterPSK)
+		if err != nil {
+			return fmt.Errorf("brigade psk: %w", err)
+		}
+	}
+
+	if len(psk) == 0 {
+		return ErrEmptyPSK
+	}
+
+	var payload io.Reader = base64.NewDecoder(base64.StdEncoding, strings.NewReader(e.Payload))
+
+	if e.PayloadSHA256 != "" {
+		if opts.PayloadDir == "" {
+			return ErrEmptyPayloadDir
+		}
+
+		f, err := openDetachedPayload(e, opts.PayloadDir)
+		if err != nil {
+			return fmt.Errorf("detached payload: %w", err)
+		}
+
+		defer f.Close()
+
+		payload = f
+	}
+
+	secret := finalSecret(e.Tag, e.BrigadeID, e.GlobalSnapAt, e.LocalSnapAt, psk, opts.LockerSecret, opts.Secret)
+
+	// the header_hmac is required since v4, it can't be stripped
+	if e.Version >= snapCore.EnvelopeVersion4 || e.HeaderHMAC != "" {
+		if err := VerifyHeaderHMAC(e, psk, opts.LockerSecret); err != nil {
+			return err
+		}
+	}
+
+	info, err := DecryptDecompressSnapshotTo(w, payload, secret, PayloadOpts{
+		Compression: e.Compression,
+		Digest:      e.PlaintextDigest,
+	})
+	if err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+
+	if e.DigestHMAC != "" {
+		if err := verifyDigestHMAC(e.DigestHMAC, secret, info.Digest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyDigestHMAC checks the header HMAC of the plaintext digest.
+func verifyDigestHMAC(encoded string, secret, digest []byte) error {
+	if digest == nil {
+		return fmt.Errorf("%w: no digest in payload", ErrDigestHMACMismatch)
+	}
+
+	want, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("decode digest hmac: %w", err)
+	}
+
+	mac, err := digestHMAC(secret, digest)
+	if err != nil {
+		return fmt.Errorf("digest hmac: %w", err)
+	}
+
+	if !hmac.Equal(mac, want) {
+		return ErrDigestHMACMismatch
+	}
+
+	return nil
+}
@@ -0,0 +1,242 @@
+package snap
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+	snapCrypto "github.com/vpngen/keydesk-snap/core/crypto"
+)
+
+type testKeys struct {
+	realm *rsa.PrivateKey
+	auth  *rsa.PrivateKey
+}
+
+func genTestKeys(t *testing.T) *testKeys {
+	t.Helper()
+
+	realm, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testKeys{realm: realm, auth: auth}
+}
+
+func (k *testKeys) snapOpts(t *testing.T, psk []byte) SnapOpts {
+	t.Helper()
+
+	realmFP, err := snapCrypto.FingerprintRSAPublicKey(&k.realm.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authFP, err := snapCrypto.FingerprintRSAPublicKey(&k.auth.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return SnapOpts{
+		BrigadeID:    "brigade1",
+		Tag:          "2023-01-01T00:00:00Z-regular-quarter-snapshot",
+		GlobalSnapAt: time.Unix(1700000000, 0).UTC(),
+		PSK:          psk,
+		RealFP:       realmFP,
+		RealmKey:     &k.realm.PublicKey,
+		AuthKeys:     []*snapCrypto.RSAPublicKey{{Key: &k.auth.PublicKey, FingerPrint: authFP}},
+	}
+}
+
+func (k *testKeys) restoreOpts(t *testing.T, e *snapCore.EncryptedBrigade) RestoreOpts {
+	t.Helper()
+
+	locker, err := DecryptLockerSecret(e, k.realm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := DecryptAuthoritySecret(e, k.auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return RestoreOpts{LockerSecret: locker, Secret: secret}
+}
+
+func Test_MakeSnapshot_OpenSnapshot(t *testing.T) {
+	keys := genTestKeys(t)
+	master := bytes.Repeat([]byte{0x42}, snapCore.PSKSize)
+	data := `{"brigade_id":"brigade1","users":[]}`
+
+	for _, derivation := range []string{"", snapCore.PSKDerivationHKDFSHA256} {
+		t.Run("derivation="+derivation, func(t *testing.T) {
+			opts := keys.snapOpts(t, master)
+			opts.PSKDerivation = derivation
+
+			buf, err := MakeSnapshot(strings.NewReader(data), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			e := &snapCore.EncryptedBrigade{}
+			if err := json.Unmarshal(buf, e); err != nil {
+				t.Fatal(err)
+			}
+
+			if e.PSKDerivation != derivation {
+				t.Errorf("PSKDerivation = %q, want %q", e.PSKDerivation, derivation)
+			}
+
+			ropts := keys.restoreOpts(t, e)
+			ropts.MasterPSK = master
+
+			got, err := OpenSnapshot(e, ropts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != data {
+				t.Errorf("OpenSnapshot() = %q, want %q", got, data)
+			}
+
+			// the realm hands out the brigade PSK instead of the master PSK
+			psk, err := BrigadePSK(e, master)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if derivation != "" && bytes.Equal(psk, master) {
+				t.Error("BrigadePSK() returns the master PSK")
+			}
+
+			derived, err := DerivedPSK(e, master)
+			switch {
+			case derivation == "" && !errors.Is(err, ErrNoPSKDerivation):
+				t.Errorf("DerivedPSK() error = %v, want %v", err, ErrNoPSKDerivation)
+			case derivation != "" && (err != nil || !bytes.Equal(derived, psk)):
+				t.Errorf("DerivedPSK() = %x, %v, want %x", derived, err, psk)
+			}
+
+			ropts.MasterPSK = nil
+			ropts.PSK = psk
+
+			got, err = OpenSnapshot(e, ropts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != data {
+				t.Errorf("OpenSnapshot() with brigade PSK = %q, want %q", got, data)
+			}
+		})
+	}
+}
+
+func Test_OpenSnapshot_WrongPSK(t *testing.T) {
+	keys := genTestKeys(t)
+	opts := keys.snapOpts(t, bytes.Repeat([]byte{0x42}, snapCore.PSKSize))
+	opts.PSKDerivation = snapCore.PSKDerivationHKDFSHA256
+
+	buf, err := MakeSnapshot(strings.NewReader(`{"brigade_id":"brigade1"}`), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &snapCore.EncryptedBrigade{}
+	if err := json.Unmarshal(buf, e); err != nil {
+		t.Fatal(err)
+	}
+
+	ropts := keys.restoreOpts(t, e)
+	ropts.MasterPSK = bytes.Repeat([]byte{0x24}, snapCore.PSKSize)
+
+	if _, err := OpenSnapshot(e, ropts); err == nil {
+		t.Error("OpenSnapshot() with wrong PSK: want error")
+	}
+}
+
+func Test_MakeSnapshotTo_OpenSnapshotTo_Large(t *testing.T) {
+	keys := genTestKeys(t)
+	psk := bytes.Repeat([]byte{0x42}, snapCore.PSKSize)
+
+	// bigger than a few stream buffers and not aligned to them
+	data := bytes.Repeat([]byte(`{"user_id":"8c4a2fa8-ec2d-4bbb-b6c3-ba1b6f3e0c3a"},`), 3*StreamBufferSize/50+7)
+
+	w := &bytes.Buffer{}
+	if err := MakeSnapshotTo(w, bytes.NewReader(data), keys.snapOpts(t, psk)); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &snapCore.EncryptedBrigade{}
+	if err := json.Unmarshal(w.Bytes(), e); err != nil {
+		t.Fatalf("MakeSnapshotTo() produced invalid JSON: %s", err)
+	}
+
+	ropts := keys.restoreOpts(t, e)
+	ropts.PSK = psk
+
+	got := &bytes.Buffer{}
+	if err := OpenSnapshotTo(got, e, ropts); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got.Bytes(), data) {
+		t.Errorf("OpenSnapshotTo() len = %d, want %d", got.Len(), len(data))
+	}
+}
+
+func Test_OpenSnapshot_DigestHMAC(t *testing.T) {
+	keys := genTestKeys(t)
+	psk := bytes.Repeat([]byte{0x42}, snapCore.PSKSize)
+	data := `{"brigade_id":"brigade1"}`
+
+	opts := keys.snapOpts(t, psk)
+	opts.DigestHMAC = true
+
+	buf, err := MakeSnapshot(strings.NewReader(data), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &snapCore.EncryptedBrigade{}
+	if err := json.Unmarshal(buf, e); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.PlaintextDigest != snapCore.DigestSHA256 || e.DigestHMAC == "" {
+		t.Fatalf("MakeSnapshot() digest = %q, hmac = %q", e.PlaintextDigest, e.DigestHMAC)
+	}
+
+	ropts := keys.restoreOpts(t, e)
+	ropts.PSK = psk
+
+	got, err := OpenSnapshot(e, ropts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != data {
+		t.Errorf("OpenSnapshot() = %q, want %q", got, data)
+	}
+
+	// the header hmac covers the digest hmac, sign the tampered header
+	e.DigestHMAC = base64.StdEncoding.EncodeToString(make([]byte, 32))
+	signHeader(t, e, psk, ropts.LockerSecret)
+
+	if _, err := OpenSnapshot(e, ropts); !errors.Is(err, ErrDigestHMACMismatch) {
+		t.Errorf("OpenSnapshot() error = %v, want %v", err, ErrDigestHMACMismatch)
+	}
+}
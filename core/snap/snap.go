@@ -2,10 +2,8 @@ package snap
 
 import (
 	"bytes"
-	"compress/gzip"
 	"crypto/rsa"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"io"
 	"time"
@@ -22,6 +20,41 @@ type SnapOpts struct {
 	RealFP       string
 	RealmKey     *rsa.PublicKey
 	AuthKeys     []*snapCrypto.RSAPublicKey
+
+	// PSKDerivation is a method of the brigade PSK derivation
+	// from the PSK. Empty means the PSK is used as is.
+	PSKDerivation string
+	// PayloadFormat is a format of the plain payload.
+	// Empty means brigade.json as is.
+	PayloadFormat string
+	// Compression is a payload compression algorithm. Empty means gzip.
+	Compression string
+	// CompressionLevel is an algorithm specific level.
+	// Zero means the algorithm default level.
+	CompressionLevel int
+	// DigestHMAC adds the HMAC of the plaintext digest to the header.
+	DigestHMAC bool
+	// Padding is a payload size padding policy.
+	Padding Padding
+	// BaseTag and BaseDigest refer to the base snapshot
+	// of the incremental snapshot.
+	BaseTag    string
+	BaseDigest string
+	// RedactionPolicy is a digest of the applied redaction policy.
+	RedactionPolicy string
+	// StorageVersion and KeydeskVersion are the versions
+	// of the keydesk storage schema and module.
+	StorageVersion int
+	KeydeskVersion string
+	// Encoding is an envelope encoding. Empty means JSON.
+	Encoding string
+	// DetachedDir is a dir for the content addressed payload file.
+	// Empty means the payload is inline.
+	DetachedDir string
+	// Meta returns the public metadata when the payload is streamed,
+	// the plaintext size is set by the snapshot. Nil means no metadata.
+	// The metadata is not allowed with the padding.
+	Meta func() (*snapCore.SnapshotMeta, error)
 }
 
 type secretsPack struct {
@@ -34,32 +67,74 @@ type secretsPack struct {
 const (
 	LockerSecretSize = 16
 	SecretSize       = 16
+
+	// StreamBufferSize is a size of the stream pipeline buffers.
+	StreamBufferSize = snapCrypto.StreamBufferSize
 )
 
-var ErrEmptyTag = fmt.Errorf("empty tag")
+var (
+	ErrEmptyTag             = fmt.Errorf("empty tag")
+	ErrUnknownPSKDerivation = fmt.Errorf("unknown psk derivation")
+)
 
+// MakeSnapshot makes the snapshot in memory.
+// Use MakeSnapshotTo for large brigades.
 func MakeSnapshot(r io.Reader, opts SnapOpts) ([]byte, error) {
-	secrets, err := genSecrets(opts.Tag, opts.BrigadeID, opts.GlobalSnapAt, opts.PSK)
+	w := &bytes.Buffer{}
+
+	if err := MakeSnapshotTo(w, r, opts); err != nil {
+		return nil, err
+	}
+
+	return w.Bytes(), nil
+}
+
+// MakeSnapshotTo streams the snapshot envelope to the writer.
+// The data is piped read->compress->encrypt->base64->JSON
+// (or read->compress->encrypt->binary for the binary encoding)
+// with fixed-size buffers, so the memory usage doesn't depend
+// on the brigade size. On error the written data must be discarded.
+func MakeSnapshotTo(w io.Writer, r io.Reader, opts SnapOpts) error {
+	_, err := MakeSnapshotIDTo(w, r, opts)
+
+	return err
+}
+
+// MakeSnapshotIDTo is MakeSnapshotTo which also returns
+// the snapshot ID, see SnapshotID.
+func MakeSnapshotIDTo(w io.Writer, r io.Reader, opts SnapOpts) (string, error) {
+	encode, ok := envelopeEncoders[encodingName(opts.Encoding)]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownEncoding, opts.Encoding)
+	}
+
+	// the meta has the plaintext size and the users count
+	if opts.Meta != nil && !opts.Padding.IsZero() {
+		return "", ErrMetaPadding
+	}
+
+	psk, err := brigadePSK(opts.PSKDerivation, opts.PSK, opts.Tag, opts.BrigadeID, opts.GlobalSnapAt)
 	if err != nil {
-		return nil, fmt.Errorf("gen secrets: %w", err)
+		return "", fmt.Errorf("brigade psk: %w", err)
 	}
 
-	encryptedLockerSecret, err := snapCrypto.EncryptSecret(opts.RealmKey, secrets.LockerSecret)
+	secrets, err := genSecrets(opts.Tag, opts.BrigadeID, opts.GlobalSnapAt, psk)
 	if err != nil {
-		return nil, fmt.Errorf("encrypt locker secret: %w", err)
+		return "", fmt.Errorf("gen secrets: %w", err)
 	}
 
-	encryptedSecrets, err := snapCrypto.EncryptSecretForAuthorities(opts.AuthKeys, secrets.Secret)
+	encryptedLockerSecret, err := snapCrypto.EncryptSecret(opts.RealmKey, secrets.LockerSecret)
 	if err != nil {
-		return nil, fmt.Errorf("encrypt secrets: %w", err)
+		return "", fmt.Errorf("encrypt locker secret: %w", err)
 	}
 
-	payload, err := CompressEncryptSnapshot(r, secrets.FinalSecret)
+	encryptedSecrets, err := snapCrypto.EncryptSecretForAuthorities(opts.AuthKeys, secrets.Secret)
 	if err != nil {
-		return nil, fmt.Errorf("snapshot: %w", err)
+		return "", fmt.Errorf("encrypt secrets: %w", err)
 	}
 
 	encryptedBrigade := &snapCore.EncryptedBrigade{
+		Version:   snapCore.EnvelopeVersion,
 		Tag:       opts.Tag,
 		BrigadeID: opts.BrigadeID,
 
@@ -71,56 +146,120 @@ func MakeSnapshot(r io.Reader, opts SnapOpts) ([]byte, error) {
 
 		Secrets: encryptedSecrets,
 
-		Payload: base64.StdEncoding.EncodeToString(payload),
+		PSKDerivation: opts.PSKDerivation,
+		PayloadFormat: opts.PayloadFormat,
+		Compression:   compressionAlgorithm(opts.Compression),
+
+		PlaintextDigest: snapCore.DigestSHA256,
+
+		BaseTag:    opts.BaseTag,
+		BaseDigest: opts.BaseDigest,
+
+		RedactionPolicy: opts.RedactionPolicy,
+
+		StorageVersion: opts.StorageVersion,
+		KeydeskVersion: opts.KeydeskVersion,
 	}
 
-	data, err := json.MarshalIndent(encryptedBrigade, "", "  ")
-	if err != nil {
-		return nil, fmt.Errorf("marshal: %w", err)
+	payloadOpts := PayloadOpts{
+		Compression:      opts.Compression,
+		CompressionLevel: opts.CompressionLevel,
+		Digest:           encryptedBrigade.PlaintextDigest,
+		Padding:          opts.Padding,
 	}
 
-	return data, nil
-}
+	var info *PayloadInfo
 
-func CompressEncryptSnapshot(r io.Reader, secret []byte) ([]byte, error) {
-	buf, err := io.ReadAll(r)
-	if err != nil {
-		return nil, fmt.Errorf("read all: %w", err)
+	sum := newPayloadSum()
+
+	payload := func(pw io.Writer) error {
+		var err error
+
+		info, err = CompressEncryptSnapshotTo(io.MultiWriter(pw, sum), r, secrets.FinalSecret, payloadOpts)
+
+		return err
 	}
 
-	rz := &bytes.Buffer{}
-	wz := gzip.NewWriter(rz)
+	trailer := func() (any, error) {
+		if opts.DigestHMAC {
+			mac, err := digestHMAC(secrets.FinalSecret, info.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("digest hmac: %w", err)
+			}
+
+			encryptedBrigade.DigestHMAC = base64.StdEncoding.EncodeToString(mac)
+		}
+
+		if opts.Meta != nil {
+			meta, err := opts.Meta()
+			if err != nil {
+				return nil, fmt.Errorf("meta: %w", err)
+			}
+
+			meta.PlaintextSize = info.PlainSize
+			encryptedBrigade.Meta = meta
+		}
+
+		fp, size := payloadRef(encryptedBrigade, sum)
+
+		canonical, err := canonicalEnvelope(encryptedBrigade, fp, size)
+		if err != nil {
+			return nil, fmt.Errorf("canonical: %w", err)
+		}
+
+		mac, err := headerHMAC(psk, secrets.LockerSecret, canonical)
+		if err != nil {
+			return nil, fmt.Errorf("header hmac: %w", err)
+		}
+
+		encryptedBrigade.HeaderHMAC = base64.StdEncoding.EncodeToString(mac)
+
+		return &envelopeTrailer{
+			DigestHMAC: encryptedBrigade.DigestHMAC,
+			Meta:       encryptedBrigade.Meta,
+			HeaderHMAC: encryptedBrigade.HeaderHMAC,
+		}, nil
+	}
+
+	if opts.DetachedDir != "" {
+		if err := writeDetached(encryptedBrigade, opts.DetachedDir, payload); err != nil {
+			return "", fmt.Errorf("detached payload: %w", err)
+		}
+
+		payload = nil
+	}
 
-	if _, err := bytes.NewBuffer(buf).WriteTo(wz); err != nil {
-		return nil, fmt.Errorf("write to: %w", err)
+	if err := encode(w, encryptedBrigade, payload, trailer); err != nil {
+		return "", fmt.Errorf("snapshot: %w", err)
 	}
 
-	wz.Flush()
-	wz.Close()
+	fp, size := payloadRef(encryptedBrigade, sum)
 
-	w := &bytes.Buffer{}
-	if err := snapCrypto.EncryptAES256CBC(rz, w, secret); err != nil {
-		return nil, fmt.Errorf("encrypt: %w", err)
+	canonical, err := canonicalEnvelope(encryptedBrigade, fp, size)
+	if err != nil {
+		return "", fmt.Errorf("snapshot id: %w", err)
 	}
 
-	return w.Bytes(), nil
+	return snapshotID(canonical), nil
 }
 
-func DecryptDecompressSnapshot(r io.Reader, secret []byte) ([]byte, error) {
-	wz := &bytes.Buffer{}
+// CompressEncryptSnapshot compresses with gzip and encrypts the data in memory.
+func CompressEncryptSnapshot(r io.Reader, secret []byte) ([]byte, error) {
+	w := &bytes.Buffer{}
 
-	if err := snapCrypto.DecryptAES256CBC(r, wz, secret); err != nil {
-		return nil, fmt.Errorf("decrypt: %w", err)
+	if _, err := CompressEncryptSnapshotTo(w, r, secret, PayloadOpts{}); err != nil {
+		return nil, err
 	}
 
-	rz, err := gzip.NewReader(wz)
-	if err != nil {
-		return nil, fmt.Errorf("gzip: %w", err)
-	}
+	return w.Bytes(), nil
+}
 
+// DecryptDecompressSnapshot decrypts and decompresses with gzip the data in memory.
+func DecryptDecompressSnapshot(r io.Reader, secret []byte) ([]byte, error) {
 	w := &bytes.Buffer{}
-	if _, err := w.ReadFrom(rz); err != nil {
-		return nil, fmt.Errorf("read from: %w", err)
+
+	if _, err := DecryptDecompressSnapshotTo(w, r, secret, PayloadOpts{}); err != nil {
+		return nil, err
 	}
 
 	return w.Bytes(), nil
@@ -144,14 +283,30 @@ func genSecrets(tag string, id string, gt time.Time, psk []byte) (*secretsPack,
 		return nil, fmt.Errorf("gen secret: %w", err)
 	}
 
-	finalSecret := make([]byte, 0, len([]byte(tag))+len([]byte(id))+8+8+len(psk)+len(locker)+len(secret))
-
-	finalSecret = fmt.Append(finalSecret, tag, id, gt.Unix(), lt.Unix(), psk, locker, secret)
-
 	return &secretsPack{
 		LockerSecret: locker,
 		Secret:       secret,
-		FinalSecret:  finalSecret,
+		FinalSecret:  finalSecret(tag, id, gt, lt, psk, locker, secret),
 		LocalSnapAt:  lt,
 	}, nil
 }
+
+// finalSecret concatenates all parts of the final secret.
+// It is used both by the snapshot and by the restore side.
+func finalSecret(tag string, id string, gt, lt time.Time, psk, locker, secret []byte) []byte {
+	buf := make([]byte, 0, len([]byte(tag))+len([]byte(id))+8+8+len(psk)+len(locker)+len(secret))
+
+	return fmt.Append(buf, tag, id, gt.Unix(), lt.Unix(), psk, locker, secret)
+}
+
+// brigadePSK returns the PSK for the brigade according to the derivation method.
+func brigadePSK(derivation string, psk []byte, tag string, id string, gt time.Time) ([]byte, error) {
+	switch derivation {
+	case "":
+		return psk, nil
+	case snapCore.PSKDerivationHKDFSHA256:
+		return snapCrypto.DeriveBrigadePSK(psk, tag, id, gt)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPSKDerivation, derivation)
+	}
+}
@@ -0,0 +1,86 @@
+package snap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	snapCore "github.com/vpngen/keydesk-snap/core"
+)
+
+const envelopeIndent = "  "
+
+// envelopeTrailer is a set of the envelope fields
+// known only after the payload is streamed.
+type envelopeTrailer struct {
+	DigestHMAC string                 `json:"digest_hmac,omitempty"`
+	Meta       *snapCore.SnapshotMeta `json:"meta,omitempty"`
+	HeaderHMAC string                 `json:"header_hmac,omitempty"`
+}
+
+// writeEnvelope writes the indented envelope JSON with the payload field
+// streamed by the payload function as base64 and the trailer fields
+// at the end of the object. The trailer function can return nil.
+// The payload function is nil if the payload is detached.
+func writeEnvelope(
+	w io.Writer,
+	e *snapCore.EncryptedBrigade,
+	payload func(w io.Writer) error,
+	trailer func() (any, error),
+) error {
+	e.Payload = ""
+
+	head, err := json.MarshalIndent(e, "", envelopeIndent)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	bw := bufio.NewWriterSize(w, StreamBufferSize)
+
+	bw.Write(bytes.TrimSuffix(head, []byte("\n}")))
+
+	if payload != nil {
+		bw.WriteString(",\n" + envelopeIndent + `"payload": "`)
+
+		enc := base64.NewEncoder(base64.StdEncoding, bw)
+
+		if err := payload(enc); err != nil {
+			return fmt.Errorf("payload: %w", err)
+		}
+
+		if err := enc.Close(); err != nil {
+			return fmt.Errorf("base64: %w", err)
+		}
+
+		bw.WriteString(`"`)
+	}
+
+	tail, err := trailer()
+	if err != nil {
+		return fmt.Errorf("trailer: %w", err)
+	}
+
+	if tail != nil {
+		fields, err := json.MarshalIndent(tail, "", envelopeIndent)
+		if err != nil {
+			return fmt.Errorf("marshal trailer: %w", err)
+		}
+
+		fields = bytes.TrimSuffix(bytes.TrimPrefix(fields, []byte("{")), []byte("\n}"))
+		if len(fields) > 0 {
+			bw.WriteString(",")
+			bw.Write(fields)
+		}
+	}
+
+	bw.WriteString("\n}")
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package core
+
+// Machine readable codes of the brigade snapshot failures.
+const (
+	SnapErrorKeyNotFound      = "key_not_found"
+	SnapErrorIntegrity        = "integrity_mismatch"
+	SnapErrorLockTimeout      = "lock_timeout"
+	SnapErrorPermissionDenied = "permission_denied"
+	SnapErrorInvalidPSK       = "invalid_psk"
+	// SnapErrorValidationFailed and SnapErrorUnsupportedStorageVersion
+	// are permanent, the retry of the same brigade fails the same way.
+	SnapErrorValidationFailed          = "validation_failed"
+	SnapErrorUnsupportedStorageVersion = "unsupported_storage_version"
+	SnapErrorOther                     = "other"
+)
+
+// Exit codes of the snapshot tool for each kind of the brigade failure.
+// The PSK failures have the psk package exit codes.
+const (
+	ExitCodeKeyNotFound      = 20
+	ExitCodeIntegrity        = 21
+	ExitCodeLockTimeout      = 22
+	ExitCodePermissionDenied = 23
+	ExitCodeValidationFailed = 24
+
+	ExitCodeUnsupportedStorageVersion = 25
+)
+
+// SnapErrorCode returns the failure code for the snapshot tool exit code.
+func SnapErrorCode(exitCode int) string {
+	switch exitCode {
+	case ExitCodeKeyNotFound:
+		return SnapErrorKeyNotFound
+	case ExitCodeIntegrity:
+		return SnapErrorIntegrity
+	case ExitCodeLockTimeout:
+		return SnapErrorLockTimeout
+	case ExitCodePermissionDenied:
+		return SnapErrorPermissionDenied
+	case ExitCodeValidationFailed:
+		return SnapErrorValidationFailed
+	case ExitCodeUnsupportedStorageVersion:
+		return SnapErrorUnsupportedStorageVersion
+	default:
+		return SnapErrorOther
+	}
+}
@@ -0,0 +1,25 @@
+package core
+
+import "testing"
+
+func Test_SnapErrorCode(t *testing.T) {
+	tests := []struct {
+		exitCode int
+		want     string
+	}{
+		{exitCode: ExitCodeKeyNotFound, want: SnapErrorKeyNotFound},
+		{exitCode: ExitCodeIntegrity, want: SnapErrorIntegrity},
+		{exitCode: ExitCodeLockTimeout, want: SnapErrorLockTimeout},
+		{exitCode: ExitCodePermissionDenied, want: SnapErrorPermissionDenied},
+		{exitCode: ExitCodeValidationFailed, want: SnapErrorValidationFailed},
+		{exitCode: ExitCodeUnsupportedStorageVersion, want: SnapErrorUnsupportedStorageVersion},
+		{exitCode: 1, want: SnapErrorOther},
+		{exitCode: 255, want: SnapErrorOther},
+	}
+
+	for _, tt := range tests {
+		if got := SnapErrorCode(tt.exitCode); got != tt.want {
+			t.Errorf("SnapErrorCode(%d) = %q, want %q", tt.exitCode, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Snapshot kinds of the tag.
+const (
+	TagKindRegular   = "regular"
+	TagKindEmergency = "emergency"
+	TagKindManual    = "manual"
+)
+
+// TagGrammarEnvelopeVersion is the first envelope version made only
+// with the tag grammar. The version 3 envelopes can have the free form tags.
+const TagGrammarEnvelopeVersion = EnvelopeVersion4
+
+var (
+	tagPeriodRe = regexp.MustCompile(`^[a-z0-9]+$`)
+	tagLabelRe  = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
+// Tag is a parsed snapshot tag:
+//
+//	<RFC3339 time>-<kind>-<period>-<label>
+//	2023-01-01T00:00:00Z-regular-quarter-snapshot
+//
+// The time is the global snapshot time, the kind is one of TagKind*,
+// the period is a lowercase word and the label is a free word
+// which can contain dashes.
+type Tag struct {
+	Time   time.Time
+	Kind   string
+	Period string
+	Label  string
+}
+
+// ParseTag parses the tag. The first kind separator
+// ends the time, so the label can contain the kind words.
+func ParseTag(s string) (Tag, error) {
+	kind, pos := "", -1
+
+	for _, k := range []string{TagKindRegular, TagKindEmergency, TagKindManual} {
+		if i := strings.Index(s, "-"+k+"-"); i >= 0 && (pos < 0 || i < pos) {
+			kind, pos = k, i
+		}
+	}
+
+	if pos < 0 {
+		return Tag{}, fmt.Errorf("%w: %q: no kind", ErrInvalidTag, s)
+	}
+
+	t, err := time.Parse(time.RFC3339, s[:pos])
+	if err != nil {
+		return Tag{}, fmt.Errorf("%w: %q: time: %w", ErrInvalidTag, s, err)
+	}
+
+	period, label, _ := strings.Cut(s[pos+len(kind)+2:], "-")
+
+	if !tagPeriodRe.MatchString(period) {
+		return Tag{}, fmt.Errorf("%w: %q: period: %q", ErrInvalidTag, s, period)
+	}
+
+	if !tagLabelRe.MatchString(label) {
+		return Tag{}, fmt.Errorf("%w: %q: label: %q", ErrInvalidTag, s, label)
+	}
+
+	return Tag{Time: t, Kind: kind, Period: period, Label: label}, nil
+}
+
+// String returns the tag in the canonical form with UTC time.
+func (t Tag) String() string {
+	return t.Time.UTC().Format(time.RFC3339) + "-" + t.Kind + "-" + t.Period + "-" + t.Label
+}
+
+// CheckTime checks the tag time is the global snapshot time.
+func (t Tag) CheckTime(gt time.Time) error {
+	if !t.Time.Equal(gt) {
+		return fmt.Errorf("%w: %s, global snapshot time %s",
+			ErrTagTime, t.Time.UTC().Format(time.RFC3339), gt.UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
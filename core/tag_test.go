@@ -0,0 +1,73 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_ParseTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want Tag
+		err  error
+	}{
+		{
+			tag:  "2023-01-01T00:00:00Z-regular-quarter-snapshot",
+			want: Tag{Time: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), Kind: TagKindRegular, Period: "quarter", Label: "snapshot"},
+		},
+		{
+			tag:  "2023-01-01T03:00:00+03:00-emergency-daily-host-1.example",
+			want: Tag{Time: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), Kind: TagKindEmergency, Period: "daily", Label: "host-1.example"},
+		},
+		{
+			tag:  "2023-01-01T00:00:00-05:00-manual-once-regular-check",
+			want: Tag{Time: time.Date(2023, 1, 1, 5, 0, 0, 0, time.UTC), Kind: TagKindManual, Period: "once", Label: "regular-check"},
+		},
+		{tag: "", err: ErrInvalidTag},
+		{tag: "snapshot-test", err: ErrInvalidTag},
+		{tag: "2023-01-01-regular-quarter-snapshot", err: ErrInvalidTag},
+		{tag: "2023-01-01T00:00:00Z-weekly-quarter-snapshot", err: ErrInvalidTag},
+		{tag: "2023-01-01T00:00:00Z-regular-quarter", err: ErrInvalidTag},
+		{tag: "2023-01-01T00:00:00Z-regular--snapshot", err: ErrInvalidTag},
+		{tag: "2023-01-01T00:00:00Z-regular-Quarter-snapshot", err: ErrInvalidTag},
+		{tag: "2023-01-01T00:00:00Z-regular-quarter-snap/shot", err: ErrInvalidTag},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			got, err := ParseTag(tt.tag)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ParseTag() error = %v, want %v", err, tt.err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if !got.Time.Equal(tt.want.Time) || got.Kind != tt.want.Kind || got.Period != tt.want.Period || got.Label != tt.want.Label {
+				t.Errorf("ParseTag() = %+v, want %+v", got, tt.want)
+			}
+
+			again, err := ParseTag(got.String())
+			if err != nil || !again.Time.Equal(got.Time) || again.Label != got.Label {
+				t.Errorf("ParseTag(String()) = %+v, %v, want %+v", again, err, got)
+			}
+		})
+	}
+}
+
+func Test_Tag_CheckTime(t *testing.T) {
+	tag, err := ParseTag("2023-11-14T22:13:20Z-regular-quarter-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tag.CheckTime(time.Unix(1700000000, 0)); err != nil {
+		t.Errorf("CheckTime() error = %v", err)
+	}
+
+	if err := tag.CheckTime(time.Unix(1700000001, 0)); !errors.Is(err, ErrTagTime) {
+		t.Errorf("CheckTime() error = %v, want %v", err, ErrTagTime)
+	}
+}
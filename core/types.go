@@ -11,18 +11,29 @@ type EncryptedSecretPair map[string]string
 // PSK used but not stored in the snapshot.
 // Final secret: Tag + [8]byte(unixtime(GlobaSnapAt)) + [8]byte(unixtime(LocalSnapAt)) + PSK + LockerSecret + Secret
 type EncryptedBrigade struct {
+	// Version is an envelope format version.
+	// Absent version means snapCore.EnvelopeVersion1.
+	Version int `json:"version,omitempty"`
+
 	// identification tag, using to ident whole snapshot.
-	// 2023-01-01T00:00:00Z-regular-quarter-snapshot
+	// 2023-01-01T00:00:00Z-regular-quarter-snapshot, see Tag.
 	Tag string `json:"tag"`
 
 	// GlobalSnapAt is a time of the global snapshot start.
 	// It is used to identify the snapshot.
 	GlobalSnapAt time.Time `json:"global_snap_at"`
 
-	BrigadeID   string    `json:"brigade_id"`
-	Payload     string    `json:"payload"`
+	BrigadeID string `json:"brigade_id"`
+	// Payload is a base64 encoded encrypted payload.
+	// It is empty if the payload is detached.
+	Payload     string    `json:"payload,omitempty"`
 	LocalSnapAt time.Time `json:"local_snap_at"`
 
+	// PayloadSHA256 is a hex SHA-256 of the detached encrypted payload,
+	// the payload file is named by it. PayloadSize is its size in bytes.
+	PayloadSHA256 string `json:"payload_sha256,omitempty"`
+	PayloadSize   int64  `json:"payload_size,omitempty"`
+
 	// RealmKeyFP is a fingerprint of the realm public key with which
 	// the LockerSecret was encrypted.
 	RealmKeyFP string `json:"realm_key_fp"`
@@ -38,4 +49,109 @@ type EncryptedBrigade struct {
 
 	// Secrets is a map of encrypted main secrets.
 	Secrets EncryptedSecretPair `json:"sss_keys"`
+
+	// PSKDerivation is a method of the brigade PSK derivation
+	// from the master PSK. Empty means the PSK is used as is.
+	PSKDerivation string `json:"psk_derivation,omitempty"`
+
+	// PayloadFormat is a format of the decrypted payload.
+	// Empty means brigade.json as is.
+	PayloadFormat string `json:"payload_format,omitempty"`
+
+	// Compression is a payload compression algorithm.
+	// Empty means gzip.
+	Compression string `json:"compression,omitempty"`
+
+	// PlaintextDigest is an algorithm of the plaintext digest
+	// stored inside the encrypted payload. Empty means no digest.
+	PlaintextDigest string `json:"plaintext_digest,omitempty"`
+	// DigestHMAC is an optional HMAC of the plaintext digest
+	// with the key derived from the final secret.
+	DigestHMAC string `json:"digest_hmac,omitempty"`
+
+	// BaseTag is a tag of the base snapshot of the incremental snapshot.
+	BaseTag string `json:"base_tag,omitempty"`
+	// BaseDigest is a base64 SHA-256 digest of the canonical
+	// base snapshot plaintext of the incremental snapshot.
+	BaseDigest string `json:"base_digest,omitempty"`
+
+	// RedactionPolicy is a base64 SHA-256 digest of the redaction
+	// policy applied to the payload. Empty means no redaction.
+	RedactionPolicy string `json:"redaction_policy,omitempty"`
+
+	// StorageVersion is a keydesk storage schema version of the brigade.
+	StorageVersion int `json:"storage_version,omitempty"`
+	// KeydeskVersion is a keydesk module version the snapshot tool is built with.
+	KeydeskVersion string `json:"keydesk_version,omitempty"`
+
+	// Meta is an optional public metadata, it is not encrypted.
+	// It is authenticated by the HeaderHMAC, the readers without
+	// the PSK and the realm key must not trust it.
+	Meta *SnapshotMeta `json:"meta,omitempty"`
+	// HeaderHMAC is a base64 HMAC of the canonical envelope without it,
+	// with the key derived from the brigade PSK and the locker secret.
+	// It authenticates the header fields, the meta and the encrypted
+	// payload, the realm verifies it without the authority keys.
+	// It is required since v4.
+	HeaderHMAC string `json:"header_hmac,omitempty"`
+}
+
+// SnapshotMeta is a public unencrypted metadata of the snapshot
+// for the operations reports. It is an allowlist: nothing but
+// these fields can be put into the envelope unencrypted.
+// It is a hint until the header HMAC is verified.
+type SnapshotMeta struct {
+	// Users is a number of the brigade users.
+	Users int `json:"users"`
+	// PlaintextSize is a size of the plain payload.
+	PlaintextSize int64 `json:"plaintext_size"`
+	// KeydeskVersion is a keydesk module version the snapshot tool is built with.
+	KeydeskVersion string `json:"keydesk_version,omitempty"`
+	// MaintenanceTill is a unix time of the brigade maintenance end.
+	// Zero means no maintenance.
+	MaintenanceTill int64 `json:"maintenance_till,omitempty"`
+}
+
+// SnapBundle is a result of the brigades snapshots of the host.
+type SnapBundle struct {
+	Snaps []*EncryptedBrigade `json:"snaps"`
+	// Errors are the failed brigades.
+	Errors      []SnapError `json:"errors,omitempty"`
+	TotalCount  int         `json:"total_count"`
+	ErrorsCount int         `json:"errors_count"`
+	// Discovered are the brigades found on the host
+	// when no list is given.
+	Discovered []string `json:"discovered,omitempty"`
+}
+
+// SnapError is a failure of the brigade snapshot.
+type SnapError struct {
+	BrigadeID string `json:"brigade_id"`
+	// Code is a machine readable failure code, see SnapErrorCode.
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// SnapRecord is a line of the streamed snapshots of the host.
+// The field of the record type is set only.
+type SnapRecord struct {
+	Type       string            `json:"type"`
+	Discovered []string          `json:"discovered,omitempty"`
+	Snap       *EncryptedBrigade `json:"snap,omitempty"`
+	Error      *SnapError        `json:"error,omitempty"`
+	Summary    *SnapSummary      `json:"summary,omitempty"`
+}
+
+// SnapSummary is a last record of the streamed snapshots.
+type SnapSummary struct {
+	TotalCount  int `json:"total_count"`
+	ErrorsCount int `json:"errors_count"`
+}
+
+// ErrorResponse is a failure of the whole request.
+type ErrorResponse struct {
+	Code    int    `json:"code"`
+	Desc    string `json:"desc"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
 }
@@ -0,0 +1,198 @@
+// Package validate checks the decoded brigade database
+// before it is snapshotted.
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/google/uuid"
+	"github.com/vpngen/keydesk/keydesk/storage"
+)
+
+// Validation strictness levels.
+const (
+	// StrictnessOff skips the validation.
+	StrictnessOff = "off"
+	// StrictnessWarn reports the problems and makes the snapshot anyway.
+	StrictnessWarn = "warn"
+	// StrictnessStrict refuses to make the snapshot on any problem.
+	StrictnessStrict = "strict"
+)
+
+// WgKeySize is a WireGuard key size.
+const WgKeySize = 32
+
+var (
+	ErrUnknownStrictness  = errors.New("unknown validation strictness")
+	ErrInvalid            = errors.New("invalid brigade")
+	ErrNilUser            = errors.New("nil user")
+	ErrEmptyUserID        = errors.New("empty user id")
+	ErrDuplicateUserID    = errors.New("duplicate user id")
+	ErrInvalidWgKey       = errors.New("invalid wireguard key")
+	ErrDuplicateWgKey     = errors.New("duplicate wireguard key")
+	ErrInvalidAddr        = errors.New("invalid address")
+	ErrDuplicateAddr      = errors.New("duplicate address")
+	ErrInvalidEndpoint    = errors.New("invalid endpoint")
+	ErrTooManyUsers       = errors.New("too many users")
+	ErrTooManyBrigadiers  = errors.New("too many brigadiers")
+	ErrInconsistentQuota  = errors.New("inconsistent quota")
+	ErrInconsistentCounts = errors.New("inconsistent users counters")
+)
+
+// CheckStrictness checks the strictness level name.
+func CheckStrictness(s string) error {
+	switch s {
+	case StrictnessOff, StrictnessWarn, StrictnessStrict:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownStrictness, s)
+	}
+}
+
+// Brigade returns all found problems of the brigade.
+// Every problem wraps one of the package errors.
+func Brigade(b *storage.Brigade) []error {
+	var problems []error
+
+	report := func(err error, format string, args ...any) {
+		problems = append(problems, fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), err))
+	}
+
+	if len(b.WgPublicKey) != WgKeySize {
+		report(ErrInvalidWgKey, "brigade wg_public_key size %d", len(b.WgPublicKey))
+	}
+
+	if !(b.EndpointIPv4.IsValid() && b.EndpointIPv4.Is4()) && b.EndpointDomain == "" {
+		report(ErrInvalidEndpoint, "no endpoint_ipv4 or endpoint_domain")
+	}
+
+	if b.EndpointIPv4.IsValid() && (!b.EndpointIPv4.Is4() || b.EndpointIPv4.IsUnspecified()) {
+		report(ErrInvalidEndpoint, "endpoint_ipv4 %s", b.EndpointIPv4)
+	}
+
+	if b.EndpointPort == 0 {
+		report(ErrInvalidEndpoint, "endpoint_port is zero")
+	}
+
+	if !b.IPv4CGNAT.IsValid() || !b.IPv4CGNAT.Addr().Is4() {
+		report(ErrInvalidAddr, "ipv4_cgnat %s", b.IPv4CGNAT)
+	}
+
+	if !b.IPv6ULA.IsValid() || !b.IPv6ULA.Addr().Is6() {
+		report(ErrInvalidAddr, "ipv6_ula %s", b.IPv6ULA)
+	}
+
+	maxUsers := storage.MaxUsers
+	if b.MaxUsers > 0 {
+		maxUsers = b.MaxUsers
+	}
+
+	if uint(len(b.Users)) > maxUsers {
+		report(ErrTooManyUsers, "%d users, max %d", len(b.Users), maxUsers)
+	}
+
+	if b.ActiveUsersCount > b.TotalUsersCount {
+		report(ErrInconsistentCounts, "active %d > total %d", b.ActiveUsersCount, b.TotalUsersCount)
+	}
+
+	var (
+		ids        = make(map[uuid.UUID]int, len(b.Users))
+		wgKeys     = make(map[string]int, len(b.Users))
+		addrs      = make(map[netip.Addr]int, 2*len(b.Users))
+		brigadiers int
+	)
+
+	for i, u := range b.Users {
+		if u == nil {
+			report(ErrNilUser, "users[%d]", i)
+
+			continue
+		}
+
+		if u.IsBrigadier {
+			brigadiers++
+		}
+
+		if u.UserID == uuid.Nil {
+			report(ErrEmptyUserID, "users[%d]", i)
+		} else if j, ok := ids[u.UserID]; ok {
+			report(ErrDuplicateUserID, "users[%d] %s, see users[%d]", i, u.UserID, j)
+		} else {
+			ids[u.UserID] = i
+		}
+
+		if len(u.WgPublicKey) != WgKeySize {
+			report(ErrInvalidWgKey, "users[%d] %s: wg_public_key size %d", i, u.UserID, len(u.WgPublicKey))
+		} else if j, ok := wgKeys[string(u.WgPublicKey)]; ok {
+			report(ErrDuplicateWgKey, "users[%d] %s, see users[%d]", i, u.UserID, j)
+		} else {
+			wgKeys[string(u.WgPublicKey)] = i
+		}
+
+		for _, a := range []struct {
+			name   string
+			addr   netip.Addr
+			prefix netip.Prefix
+		}{
+			{name: "ipv4_addr", addr: u.IPv4Addr, prefix: b.IPv4CGNAT},
+			{name: "ipv6_addr", addr: u.IPv6Addr, prefix: b.IPv6ULA},
+		} {
+			if !a.addr.IsValid() || (a.prefix.IsValid() && !a.prefix.Contains(a.addr)) {
+				report(ErrInvalidAddr, "users[%d] %s: %s %s not in %s", i, u.UserID, a.name, a.addr, a.prefix)
+
+				continue
+			}
+
+			if j, ok := addrs[a.addr]; ok {
+				report(ErrDuplicateAddr, "users[%d] %s: %s %s, see users[%d]", i, u.UserID, a.name, a.addr, j)
+
+				continue
+			}
+
+			addrs[a.addr] = i
+		}
+
+		for _, c := range []struct {
+			name     string
+			counters storage.DateSummaryNetCounters
+		}{
+			{name: "counters_total", counters: u.Quotas.CountersTotal},
+			{name: "counters_wg", counters: u.Quotas.CountersWg},
+			{name: "counters_ipsec", counters: u.Quotas.CountersIPSec},
+			{name: "counters_ovc", counters: u.Quotas.CountersOvc},
+			{name: "counters_outline", counters: u.Quotas.CountersOutline},
+			{name: "counters_proto0", counters: u.Quotas.CountersProto0},
+		} {
+			if err := checkCounters(c.counters); err != nil {
+				report(err, "users[%d] %s: quotas %s", i, u.UserID, c.name)
+			}
+		}
+	}
+
+	if brigadiers > 1 {
+		report(ErrTooManyBrigadiers, "%d brigadiers", brigadiers)
+	}
+
+	return problems
+}
+
+// checkCounters checks the period counters don't exceed the total.
+func checkCounters(c storage.DateSummaryNetCounters) error {
+	for _, p := range []struct {
+		name string
+		rxtx storage.RxTx
+	}{
+		{name: "yearly", rxtx: c.Yearly},
+		{name: "monthly", rxtx: c.Monthly},
+		{name: "weekly", rxtx: c.Weekly},
+		{name: "daily", rxtx: c.Daily},
+	} {
+		if p.rxtx.Rx > c.Total.Rx || p.rxtx.Tx > c.Total.Tx {
+			return fmt.Errorf("%w: %s %d/%d > total %d/%d", ErrInconsistentQuota, p.name, p.rxtx.Rx, p.rxtx.Tx, c.Total.Rx, c.Total.Tx)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/vpngen/keydesk/keydesk/storage"
+)
+
+func testBrigade() *storage.Brigade {
+	key := func(b byte) []byte {
+		k := make([]byte, WgKeySize)
+		k[0] = b
+
+		return k
+	}
+
+	b := &storage.Brigade{
+		BrigadeID:    "AAAAAAAAAAAAAAAAAAAAAAAAAA",
+		WgPublicKey:  key(0),
+		EndpointIPv4: netip.MustParseAddr("192.0.2.1"),
+		EndpointPort: 51820,
+		IPv4CGNAT:    netip.MustParsePrefix("100.64.0.0/24"),
+		IPv6ULA:      netip.MustParsePrefix("fd00::/64"),
+	}
+
+	for i := range 3 {
+		b.Users = append(b.Users, &storage.User{
+			UserID:      uuid.New(),
+			IsBrigadier: i == 0,
+			WgPublicKey: key(byte(i + 1)),
+			IPv4Addr:    netip.AddrFrom4([4]byte{100, 64, 0, byte(i + 2)}),
+			IPv6Addr:    netip.MustParseAddr(fmt.Sprintf("fd00::%d", i+2)),
+		})
+	}
+
+	b.TotalUsersCount = len(b.Users)
+
+	return b
+}
+
+func Test_Brigade(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(b *storage.Brigade)
+		want   error
+	}{
+		{name: "valid", mutate: func(b *storage.Brigade) {}},
+		{name: "nil user", mutate: func(b *storage.Brigade) { b.Users[1] = nil }, want: ErrNilUser},
+		{name: "empty user id", mutate: func(b *storage.Brigade) { b.Users[1].UserID = uuid.Nil }, want: ErrEmptyUserID},
+		{name: "duplicate user id", mutate: func(b *storage.Brigade) { b.Users[2].UserID = b.Users[1].UserID }, want: ErrDuplicateUserID},
+		{name: "short wg key", mutate: func(b *storage.Brigade) { b.Users[1].WgPublicKey = []byte{1} }, want: ErrInvalidWgKey},
+		{name: "duplicate wg key", mutate: func(b *storage.Brigade) { b.Users[2].WgPublicKey = b.Users[1].WgPublicKey }, want: ErrDuplicateWgKey},
+		{name: "brigade wg key", mutate: func(b *storage.Brigade) { b.WgPublicKey = nil }, want: ErrInvalidWgKey},
+		{name: "addr out of net", mutate: func(b *storage.Brigade) { b.Users[1].IPv4Addr = netip.MustParseAddr("10.0.0.1") }, want: ErrInvalidAddr},
+		{name: "duplicate addr", mutate: func(b *storage.Brigade) { b.Users[2].IPv6Addr = b.Users[1].IPv6Addr }, want: ErrDuplicateAddr},
+		{name: "no endpoint", mutate: func(b *storage.Brigade) { b.EndpointIPv4 = netip.Addr{} }, want: ErrInvalidEndpoint},
+		{name: "domain endpoint", mutate: func(b *storage.Brigade) { b.EndpointIPv4, b.EndpointDomain = netip.Addr{}, "vpn.example.com" }},
+		{name: "zero port", mutate: func(b *storage.Brigade) { b.EndpointPort = 0 }, want: ErrInvalidEndpoint},
+		{name: "too many users", mutate: func(b *storage.Brigade) { b.MaxUsers = 2 }, want: ErrTooManyUsers},
+		{name: "two brigadiers", mutate: func(b *storage.Brigade) { b.Users[1].IsBrigadier = true }, want: ErrTooManyBrigadiers},
+		{name: "active over total", mutate: func(b *storage.Brigade) { b.ActiveUsersCount = 4 }, want: ErrInconsistentCounts},
+		{name: "monthly over total", mutate: func(b *storage.Brigade) { b.Users[1].Quotas.CountersWg.Monthly.Rx = 1 }, want: ErrInconsistentQuota},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := testBrigade()
+			tt.mutate(b)
+
+			problems := Brigade(b)
+
+			if tt.want == nil {
+				if len(problems) != 0 {
+					t.Errorf("Brigade() = %v, want no problems", problems)
+				}
+
+				return
+			}
+
+			if len(problems) != 1 || !errors.Is(problems[0], tt.want) {
+				t.Errorf("Brigade() = %v, want %v", problems, tt.want)
+			}
+		})
+	}
+}
+
+func Test_CheckStrictness(t *testing.T) {
+	for _, s := range []string{StrictnessOff, StrictnessWarn, StrictnessStrict} {
+		if err := CheckStrictness(s); err != nil {
+			t.Errorf("CheckStrictness(%q) error = %v", s, err)
+		}
+	}
+
+	if err := CheckStrictness("loose"); !errors.Is(err, ErrUnknownStrictness) {
+		t.Errorf("CheckStrictness() error = %v, want %v", err, ErrUnknownStrictness)
+	}
+}
@@ -0,0 +1,23 @@
+#!/bin/sh
+
+set -e
+
+# fetchsnaps runs the brigade snapshots as the brigade users,
+# the setuid and setgid capabilities replace sudo. Only the realm
+# service user may run it, the chown drops the capabilities,
+# so it goes first
+chown root:_onotole_ /opt/vgkeydesk-snap/fetchsnaps
+chmod 0750 /opt/vgkeydesk-snap/fetchsnaps
+setcap cap_setuid,cap_setgid=ep /opt/vgkeydesk-snap/fetchsnaps
+
+# the host key of the incremental snapshot references, only the fetch
+# reads it and passes the derived keys to the brigades
+REFERENCE_KEY=/etc/vg-keydesk-snap/reference.key
+if [ ! -s "${REFERENCE_KEY}" ]; then
+        install -d -m 0755 /etc/vg-keydesk-snap
+        umask 077
+        head -c 32 /dev/urandom > "${REFERENCE_KEY}.tmp"
+        chown root:_onotole_ "${REFERENCE_KEY}.tmp"
+        chmod 0640 "${REFERENCE_KEY}.tmp"
+        mv "${REFERENCE_KEY}.tmp" "${REFERENCE_KEY}"
+fi
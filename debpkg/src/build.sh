@@ -5,6 +5,8 @@ set -e
 export CGO_ENABLED=0
 
 go build -C keydesk-snap/cmd/snapshot -o ../../../bin/snapshot
+go build -C keydesk-snap/cmd/fetchsnaps -o ../../../bin/fetchsnaps
+go build -C keydesk-snap/cmd/sshcmd -o ../../../bin/ssh_command
 
 go install github.com/goreleaser/nfpm/v2/cmd/nfpm@latest
 
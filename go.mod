@@ -5,8 +5,11 @@ go 1.24.0
 toolchain go1.24.1
 
 require (
+	github.com/google/uuid v1.6.0
+	github.com/klauspost/compress v1.18.0
 	github.com/vpngen/keydesk v1.15.19
 	golang.org/x/crypto v0.46.0
+	golang.org/x/sys v0.39.0
 )
 
 require (
@@ -15,10 +18,8 @@ require (
 	github.com/davecgh/go-spew v1.1.2-0.20180830191138-d8f796af33cc // indirect
 	github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
 	github.com/golang-jwt/jwt/v5 v5.3.0 // indirect
-	github.com/google/uuid v1.6.0 // indirect
 	github.com/pmezard/go-difflib v1.0.1-0.20181226105442-5d4384ee4fb2 // indirect
 	github.com/stretchr/testify v1.8.4 // indirect
 	github.com/vpngen/vpngine v0.1.2-0.20240528050541-356825e04e77 // indirect
 	github.com/vpngen/wordsgens v1.0.5 // indirect
-	golang.org/x/sys v0.39.0 // indirect
 )